@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Load exact index names from a file, one per line. Blank lines and lines
+// starting with # are ignored.
+func loadAllowlist(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	allowed := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowed[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return allowed, nil
+}
+
+// Report allowlisted indices that weren't discovered at all
+func checkAllowlistMissing(allowed map[string]bool, discovered []IndexInfo) error {
+	found := make(map[string]bool, len(discovered))
+	for _, index := range discovered {
+		found[index.Name] = true
+	}
+
+	var missing []string
+	for name := range allowed {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("allowlisted indices not found: %s", strings.Join(missing, ", "))
+}
+
+// Keep only the allowlisted indices, logging every matched index that isn't on the list
+func filterAllowlist(indices []IndexInfo, allowed map[string]bool) []IndexInfo {
+	kept := make([]IndexInfo, 0, len(indices))
+	for _, index := range indices {
+		if !allowed[index.Name] {
+			log.Printf("Index %s matches the pattern but is not on the allowlist, not archiving it", index.Name)
+			continue
+		}
+		kept = append(kept, index)
+	}
+	return kept
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Min/max timestamps found in an index
+type timestampRange struct {
+	Min time.Time
+	Max time.Time
+	// Set when the aggregations returned null, e.g. for an empty index or
+	// one without the timestamp field. Min and Max are then the Unix epoch.
+	Missing bool
+}
+
+// Analyze min/max timestamps of the field in the index data
+func analyzeTimestamps(ctx context.Context, client *clusterClient, index, timestampField string) (timestampRange, error) {
+	query, err := json.Marshal(map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"min_time": map[string]interface{}{"min": map[string]string{"field": timestampField}},
+			"max_time": map[string]interface{}{"max": map[string]string{"field": timestampField}},
+		},
+	})
+	if err != nil {
+		return timestampRange{}, err
+	}
+
+	res, err := client.Search(
+		client.Search.WithContext(ctx),
+		client.Search.WithIndex(index),
+		client.Search.WithBody(bytes.NewReader(query)),
+		client.Search.WithPretty(),
+	)
+	if err != nil {
+		return timestampRange{}, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return timestampRange{}, fmt.Errorf("failed to analyze timestamps: %s", res.String())
+	}
+
+	var result struct {
+		Aggregations struct {
+			MinTime struct {
+				Value *float64 `json:"value"`
+			} `json:"min_time"`
+			MaxTime struct {
+				Value *float64 `json:"value"`
+			} `json:"max_time"`
+		} `json:"aggregations"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return timestampRange{}, err
+	}
+
+	minValue, maxValue := result.Aggregations.MinTime.Value, result.Aggregations.MaxTime.Value
+	if minValue == nil || maxValue == nil {
+		return timestampRange{Min: time.Unix(0, 0), Max: time.Unix(0, 0), Missing: true}, nil
+	}
+
+	return timestampRange{
+		Min: time.Unix(int64(*minValue/1000), 0),
+		Max: time.Unix(int64(*maxValue/1000), 0),
+	}, nil
+}
+
+// One index in the analyze-only report
+type analyzeRecord struct {
+	Index        string `json:"index"`
+	MinTimestamp string `json:"min_timestamp,omitempty"`
+	MaxTimestamp string `json:"max_timestamp,omitempty"`
+	Missing      bool   `json:"missing"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (analyzeRecord) csvHeader() []string {
+	return []string{"index", "min_timestamp", "max_timestamp", "missing", "error"}
+}
+
+func (r analyzeRecord) csvRow() []string {
+	return []string{r.Index, r.MinTimestamp, r.MaxTimestamp, strconv.FormatBool(r.Missing), r.Error}
+}
+
+// Analyze every index matching the pattern and report the ranges without snapshotting anything
+func writeAnalyzeOnly(ctx context.Context, client *clusterClient, pattern, timestampField, path, format string) error {
+	indices, err := getIndices(ctx, client, pattern)
+	if err != nil {
+		return err
+	}
+
+	var missing int
+	records := make([]analyzeRecord, len(indices))
+	for i, index := range indices {
+		records[i].Index = index.Name
+
+		timestamps, err := analyzeTimestamps(ctx, client, index.Name, timestampField)
+		if err != nil {
+			log.Printf("Error analyzing timestamps for index %s: %s", index.Name, err)
+			records[i].Error = err.Error()
+			continue
+		}
+		if timestamps.Missing {
+			log.Printf("Index %s has no timestamp values", index.Name)
+			records[i].Missing = true
+			missing++
+			continue
+		}
+		records[i].MinTimestamp = timestamps.Min.Format(time.RFC3339)
+		records[i].MaxTimestamp = timestamps.Max.Format(time.RFC3339)
+	}
+
+	if err := writeReport(path, format, records); err != nil {
+		return err
+	}
+	log.Printf("Analyzed %d indices, %d without timestamp values", len(records), missing)
+	return nil
+}
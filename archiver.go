@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings shared by every snapshot created during a run
+type archiver struct {
+	client              *clusterClient
+	repo                string
+	ismRepos            *ismRepoResolver
+	lookup              metadataLookup
+	naming              snapshotNaming
+	dryRun              bool
+	maxPendingTasks     int
+	rateLimit           *rateLimiter
+	restoreCheck        *restoreCheck
+	simulator           *failureSimulator
+	forceMerge          *forceMerge
+	catalog             *snapshotCatalog
+	progress            *progressTracker
+	noPartial           bool
+	waitForCompletion   bool
+	wait                bool
+	deleteAfterSnapshot bool
+	concurrency         int
+	waitTimeout         time.Duration
+	includeAliases      bool
+	unassignedTimeout   time.Duration
+	deleteHealthStatus  string
+	deleteHealthTimeout time.Duration
+}
+
+// Outcome of a run, counted in indices
+type runSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+
+	failures []indexFailure
+	existing []string // Indices skipped because their snapshot already exists
+}
+
+// A single index that failed during the run
+type indexFailure struct {
+	Index string
+	Step  string
+	Err   error
+}
+
+func (s *runSummary) recordFailure(index, step string, err error) {
+	s.Failed++
+	s.failures = append(s.failures, indexFailure{Index: index, Step: step, Err: err})
+}
+
+// Upper bound for --concurrency, every worker can have a snapshot running on
+// the cluster and they all compete for the same snapshot thread pool
+const maxConcurrency = 8
+
+// Snapshot the indices with a pool of concurrency workers, started in order.
+// Individual failures are logged without stopping the other indices.
+func (a *archiver) archiveIndices(ctx context.Context, indices []IndexInfo) runSummary {
+	type job struct {
+		seq  int
+		info IndexInfo
+	}
+	type outcome struct {
+		index  string
+		result indexResult
+	}
+
+	jobs := make(chan job)
+	outcomes := make(chan outcome)
+	var wg sync.WaitGroup
+	for range max(a.concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				l := newIndexLogger(j.seq, len(indices), j.info.Name)
+				a.progress.begin(j.info.Name)
+				outcomes <- outcome{index: j.info.Name, result: a.archiveIndex(ctx, l, j.info)}
+			}
+		}()
+	}
+	go func() {
+		for i, info := range indices {
+			jobs <- job{seq: i + 1, info: info}
+		}
+		close(jobs)
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// Only this goroutine touches the summary, whatever order indices finish in
+	summary := runSummary{Total: len(indices)}
+	a.progress.update(summary)
+	for o := range outcomes {
+		summary.record(o.index, o.result)
+		a.progress.end(o.index, summary)
+	}
+	return summary
+}
+
+// How archiving a single index ended
+type indexResult struct {
+	skipped bool
+	exists  bool   // The snapshot of the index already exists
+	step    string // Step that failed, when err is set
+	err     error
+}
+
+func (s *runSummary) record(index string, result indexResult) {
+	if result.exists {
+		s.existing = append(s.existing, index)
+	}
+	switch {
+	case result.err != nil:
+		s.recordFailure(index, result.step, result.err)
+	case result.skipped:
+		s.Skipped++
+	default:
+		s.Succeeded++
+	}
+}
+
+// Snapshot a single index and verify it if it is part of the restore check sample
+func (a *archiver) archiveIndex(ctx context.Context, l *indexLogger, info IndexInfo) indexResult {
+	index := info.Name
+
+	snapshotName, timestamps, err := generateSnapshotName(ctx, l, a.client, index, a.naming)
+	if errors.Is(err, errSkipIndex) {
+		return indexResult{skipped: true}
+	}
+	if err != nil {
+		l.Printf("Error generating snapshot name for index %s: %s", index, err)
+		return indexResult{step: "generating snapshot name", err: err}
+	}
+
+	repo := a.repoFor(ctx, l, index)
+
+	if a.dryRun {
+		// Existing snapshots are skipped by real runs too, don't count them as new
+		exists := snapshotExists(ctx, l, a.client, repo, snapshotName)
+		if exists {
+			l.Printf("[dry-run] Snapshot %s already exists, would skip creating it", snapshotName)
+		} else {
+			if a.forceMerge != nil {
+				l.Printf("[dry-run] Would make index %s read-only and force-merge it to %d segments", index, a.forceMerge.maxNumSegments)
+			}
+			l.Printf("[dry-run] Would create snapshot for index %s: %s (%s, %d docs)", index, snapshotName, formatBytes(info.StoreSize), info.DocsCount)
+		}
+		if a.deleteAfterSnapshot {
+			l.Printf("[dry-run] Would delete index %s once snapshot %s is verified", index, snapshotName)
+		}
+		return indexResult{skipped: true, exists: exists}
+	}
+
+	l.Printf("Creating snapshot for index %s in repository %s: %s", index, repo, snapshotName)
+
+	err = a.snapshotIndex(ctx, l, repo, index, snapshotName)
+	exists := errors.Is(err, errSnapshotExists)
+	switch {
+	case exists && !a.deleteAfterSnapshot:
+		l.Printf("Snapshot %s already exists, skipping index %s", snapshotName, index)
+		return indexResult{skipped: true, exists: true}
+	case exists:
+		l.Printf("Snapshot %s already exists, only deleting index %s once it is verified", snapshotName, index)
+	case err != nil:
+		l.Printf("Error creating snapshot for index %s: %s", index, err)
+		return indexResult{step: "creating snapshot", err: err}
+	default:
+		l.Printf("Snapshot created successfully: %s", snapshotName)
+	}
+
+	// Deleting the index is only safe once the snapshot is known to be complete
+	var snapshot snapshotInfo
+	if a.wait || a.deleteAfterSnapshot {
+		if snapshot, err = a.awaitSnapshot(ctx, l, repo, snapshotName); err != nil {
+			l.Printf("Snapshot %s did not succeed: %s", snapshotName, err)
+			return indexResult{step: "waiting for snapshot", err: err}
+		}
+	}
+
+	// Snapshots of earlier runs were cataloged and verified when they were created
+	if !exists {
+		a.catalogSnapshot(ctx, l, repo, index, snapshotName, timestamps)
+
+		if a.restoreCheck != nil && a.restoreCheck.sampled() {
+			if err := verifyRestore(ctx, l, a.client, repo, index, snapshotName, a.restoreCheck.timeout); err != nil {
+				l.Printf("Restore check failed for snapshot %s: %s", snapshotName, err)
+				return indexResult{step: "restore check", err: err}
+			}
+		}
+	}
+
+	if a.deleteAfterSnapshot {
+		if err := a.deleteArchivedIndex(ctx, l, index, snapshot); err != nil {
+			l.Printf("Not deleting index %s: %s", index, err)
+			return indexResult{step: "deleting index", err: err}
+		}
+	}
+	return indexResult{skipped: exists, exists: exists}
+}
+
+// Delete the index once its snapshot is confirmed to hold it. The snapshot may
+// have existed before this run, so its index list is checked rather than
+// trusting the name.
+func (a *archiver) deleteArchivedIndex(ctx context.Context, l *indexLogger, index string, info snapshotInfo) error {
+	if info.State != "SUCCESS" {
+		return fmt.Errorf("snapshot %s is %s, not SUCCESS", info.Snapshot, info.State)
+	}
+	if !slices.Contains(info.Indices, index) {
+		return fmt.Errorf("snapshot %s doesn't contain index %s", info.Snapshot, index)
+	}
+
+	if a.deleteHealthStatus != "" {
+		if err := waitForClusterHealth(ctx, l, a.client, "", a.deleteHealthStatus, a.deleteHealthTimeout); err != nil {
+			return err
+		}
+	}
+	if err := deleteIndex(ctx, a.client, index); err != nil {
+		return err
+	}
+	l.Printf("Deleted index %s, archived in snapshot %s", index, info.Snapshot)
+	return nil
+}
+
+// Create the snapshot for the index once the cluster is ready to take it
+func (a *archiver) snapshotIndex(ctx context.Context, l *indexLogger, repo, index, snapshot string) error {
+	// Only pace snapshots that get created, a rerun over archived indices
+	// shouldn't back off or sleep through a rate limit slot for each of them
+	if err := checkExistingSnapshot(ctx, l, a.client, repo, snapshot); err != nil {
+		return err
+	}
+
+	if a.maxPendingTasks > 0 {
+		if err := waitForPendingTasks(ctx, l, a.client, a.maxPendingTasks); err != nil {
+			return err
+		}
+	}
+
+	if a.rateLimit != nil {
+		if err := a.rateLimit.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	if a.simulator != nil && a.simulator.fail() {
+		return errSimulatedFailure
+	}
+
+	if a.forceMerge != nil {
+		if err := a.forceMerge.run(ctx, l, a.client, index); err != nil {
+			return err
+		}
+	}
+
+	opts := snapshotOptions{Metadata: a.lookup.find(index), NoPartial: a.noPartial, Wait: a.waitForCompletion, NoAliases: !a.includeAliases}
+	err := createSnapshot(ctx, l, a.client, repo, index, snapshot, opts)
+	if !a.noPartial || !isUnassignedPrimaryError(err) {
+		return err
+	}
+
+	// A primary shard is unavailable, give the cluster time to reassign it and try once more
+	l.Printf("Snapshot %s rejected because of unassigned primary shards, waiting up to %s for reassignment", snapshot, a.unassignedTimeout)
+	if waitErr := waitForAssignedPrimaries(ctx, l, a.client, index, a.unassignedTimeout); waitErr != nil {
+		return fmt.Errorf("%s (%s)", err, waitErr)
+	}
+	l.Printf("Primary shards of index %s assigned, retrying snapshot %s", index, snapshot)
+	return createSnapshot(ctx, l, a.client, repo, index, snapshot, opts)
+}
+
+// Wait until the snapshot finishes and fail unless every shard succeeded
+func (a *archiver) awaitSnapshot(ctx context.Context, l *indexLogger, repo, snapshot string) (snapshotInfo, error) {
+	info, err := waitForSnapshot(ctx, a.client, repo, snapshot, a.waitTimeout)
+	if err != nil {
+		return info, err
+	}
+	l.Printf("Snapshot %s finished in state %s: %d/%d shards failed", snapshot, info.State, info.Shards.Failed, info.Shards.Total)
+	return info, checkCompletedSnapshot(info)
+}
+
+// Reindex the sources into the target, snapshot the target and optionally delete the sources
+func (a *archiver) consolidate(ctx context.Context, sources []string, target string, deleteSources bool) error {
+	l := newIndexLogger(1, 1, target)
+	if a.dryRun {
+		l.Printf("[dry-run] Would consolidate %d indices into %s: %s", len(sources), target, strings.Join(sources, ", "))
+		return nil
+	}
+
+	if err := consolidateIndices(ctx, l, a.client, sources, target); err != nil {
+		return err
+	}
+
+	snapshotName, timestamps, err := generateSnapshotName(ctx, l, a.client, target, a.naming)
+	if err != nil {
+		return fmt.Errorf("error generating snapshot name for index %s: %s", target, err)
+	}
+
+	l.Printf("Creating snapshot for index %s: %s", target, snapshotName)
+	err = a.snapshotIndex(ctx, l, a.repo, target, snapshotName)
+	exists := errors.Is(err, errSnapshotExists)
+	switch {
+	case exists && deleteSources:
+		// The existing snapshot was taken before this reindex, the sources aren't in it
+		return fmt.Errorf("snapshot %s already exists and doesn't hold the indices reindexed into %s by this run, not deleting the sources; use a --consolidate-target that is new for every run", snapshotName, target)
+	case exists:
+		l.Printf("Snapshot %s already exists, not creating it again", snapshotName)
+	case err != nil:
+		return fmt.Errorf("error creating snapshot for index %s: %s", target, err)
+	default:
+		l.Printf("Snapshot created successfully: %s", snapshotName)
+	}
+
+	// Sources are only deleted once the snapshot of their copy is known to be complete
+	if a.wait || deleteSources {
+		if _, err := a.awaitSnapshot(ctx, l, a.repo, snapshotName); err != nil {
+			return fmt.Errorf("snapshot %s did not succeed: %s", snapshotName, err)
+		}
+	}
+	if !exists {
+		a.catalogSnapshot(ctx, l, a.repo, target, snapshotName, timestamps)
+	}
+
+	if deleteSources {
+		if a.deleteHealthStatus != "" {
+			if err := waitForClusterHealth(ctx, l, a.client, "", a.deleteHealthStatus, a.deleteHealthTimeout); err != nil {
+				return fmt.Errorf("not deleting source indices: %s", err)
+			}
+		}
+		for _, index := range sources {
+			if err := deleteIndex(ctx, a.client, index); err != nil {
+				l.Printf("Error deleting source index %s: %s", index, err)
+				continue
+			}
+			l.Printf("Deleted source index: %s", index)
+		}
+	}
+	return nil
+}
+
+// Log what a dry run would have done: how many snapshots get created, how much
+// index data they cover and how much disk deleting source indices gives back.
+// Indices whose snapshot already exists get no new snapshot.
+func logDryRunProjection(indices []IndexInfo, existing []string, consolidate, deleteSources bool) {
+	var totalSize, snapshotSize int64
+	var snapshotted int
+	for _, index := range indices {
+		totalSize += index.StoreSize
+		if !slices.Contains(existing, index.Name) {
+			snapshotSize += index.StoreSize
+			snapshotted++
+		}
+	}
+
+	snapshots := snapshotted
+	if consolidate {
+		snapshots = 1
+	}
+	log.Printf("[dry-run] Would create %d snapshots covering %d indices (%s)", snapshots, snapshotted, formatBytes(snapshotSize))
+	if len(existing) > 0 {
+		log.Printf("[dry-run] %d indices already have their snapshot and would be skipped", len(existing))
+	}
+
+	switch {
+	case consolidate && deleteSources:
+		log.Printf("[dry-run] Would delete %d source indices (%s), the consolidated index keeps a copy of their data", len(indices), formatBytes(totalSize))
+	case deleteSources:
+		log.Printf("[dry-run] Would reclaim %s by deleting %d indices", formatBytes(totalSize), len(indices))
+	default:
+		log.Println("[dry-run] No indices would be deleted, no disk would be reclaimed")
+	}
+}
+
+// Format a byte count using binary units
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Just enough of the OpenSearch API to snapshot, verify and delete indices
+type fakeSnapshotCluster struct {
+	mu        sync.Mutex
+	snapshots map[string]string // Snapshot name to the index it holds
+}
+
+func (c *fakeSnapshotCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Trim(r.URL.Path, "/")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case path == "_cluster/pending_tasks":
+		fmt.Fprint(w, `{"tasks": []}`)
+	case path == "_cluster/health":
+		fmt.Fprint(w, `{"status": "green", "timed_out": false}`)
+	case strings.HasPrefix(path, "_snapshot/backup/") && r.Method == http.MethodGet:
+		snapshot := strings.TrimPrefix(path, "_snapshot/backup/")
+		index, ok := c.snapshots[snapshot]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"type": "snapshot_missing_exception"}, "status": 404}`)
+			return
+		}
+		fmt.Fprintf(w, `{"snapshots": [{"snapshot": %q, "state": "SUCCESS", "indices": [%q], "shards": {"total": 1, "failed": 0, "successful": 1}}]}`, snapshot, index)
+	case strings.HasPrefix(path, "_snapshot/backup/"):
+		var body struct {
+			Indices string `json:"indices"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		c.snapshots[strings.TrimPrefix(path, "_snapshot/backup/")] = body.Indices
+		fmt.Fprint(w, `{"accepted": true}`)
+	case r.Method == http.MethodDelete:
+		fmt.Fprint(w, `{"acknowledged": true}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error": {"type": "unexpected request %s %s"}}`, r.Method, path)
+	}
+}
+
+func TestArchiveIndicesPrefixesLogLinesUnderConcurrency(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+
+	cluster := &fakeSnapshotCluster{snapshots: make(map[string]string)}
+	arch := &archiver{
+		client:              newTestClient(t, cluster.ServeHTTP),
+		repo:                "backup",
+		naming:              snapshotNaming{separator: ".", missingPolicy: "plain-name", lowercase: true, timeFormat: snapshotTimeFormat},
+		maxPendingTasks:     1,
+		wait:                true,
+		deleteAfterSnapshot: true,
+		concurrency:         4,
+		waitTimeout:         time.Minute,
+		includeAliases:      true,
+		deleteHealthStatus:  "green",
+		deleteHealthTimeout: time.Minute,
+	}
+
+	var indices []IndexInfo
+	for i := 1; i <= 8; i++ {
+		indices = append(indices, IndexInfo{Name: fmt.Sprintf("uat_%d", i)})
+	}
+
+	summary := arch.archiveIndices(context.Background(), indices)
+	if summary.Succeeded != len(indices) {
+		t.Fatalf("archiveIndices() = %+v, want all %d indices to succeed:\n%s", summary, len(indices), buf.String())
+	}
+
+	prefix := regexp.MustCompile(`^\[\d+/8 (uat_\d+)\] `)
+	other := regexp.MustCompile(`uat_\d+`)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for _, line := range lines {
+		match := prefix.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("log line without index prefix: %q", line)
+			continue
+		}
+		for _, mentioned := range other.FindAllString(line, -1) {
+			if mentioned != match[1] {
+				t.Errorf("log line prefixed with %s mentions %s: %q", match[1], mentioned, line)
+			}
+		}
+	}
+	if len(lines) < 4*len(indices) {
+		t.Errorf("got %d log lines, want at least 4 per index:\n%s", len(lines), buf.String())
+	}
+}
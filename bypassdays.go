@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Supported values for --bypass-days-undated
+var undatedPolicies = []string{"skip", "archive"}
+
+// A date embedded in an index name: 20240131, 2024.01.31, 2024-01-31 or 2024_01_31
+var nameDatePattern = regexp.MustCompile(`(\d{4})[._-]?(\d{2})[._-]?(\d{2})`)
+
+// Day of the index: the date in its name, or else the day of its newest document
+func indexDay(ctx context.Context, client *clusterClient, index, timestampField string) (time.Time, bool) {
+	for _, match := range nameDatePattern.FindAllStringSubmatch(index, -1) {
+		if day, err := time.Parse("20060102", match[1]+match[2]+match[3]); err == nil {
+			return day, true
+		}
+	}
+
+	timestamps, err := analyzeTimestamps(ctx, client, index, timestampField)
+	if err != nil {
+		log.Printf("Error analyzing timestamps for index %s: %s", index, err)
+		return time.Time{}, false
+	}
+	if timestamps.Missing {
+		return time.Time{}, false
+	}
+	max := timestamps.Max.UTC()
+	return time.Date(max.Year(), max.Month(), max.Day(), 0, 0, 0, 0, time.UTC), true
+}
+
+// Indices to keep because they belong to the newest number of distinct days.
+// Indices without a day are kept when the policy is skip.
+func retainedByDays(ctx context.Context, client *clusterClient, indices []IndexInfo, days int, undated, timestampField string) map[string]bool {
+	retained := make(map[string]bool)
+	indexDays := make(map[string]time.Time)
+	seen := make(map[time.Time]bool)
+	var distinct []time.Time
+	for _, index := range indices {
+		day, ok := indexDay(ctx, client, index.Name, timestampField)
+		if !ok {
+			log.Printf("No date found for index %s, --bypass-days-undated is %s", index.Name, undated)
+			if undated == "skip" {
+				retained[index.Name] = true
+			}
+			continue
+		}
+		indexDays[index.Name] = day
+		if !seen[day] {
+			seen[day] = true
+			distinct = append(distinct, day)
+		}
+	}
+	if len(distinct) == 0 {
+		return retained
+	}
+
+	// Keep everything on or after the oldest of the newest days
+	sort.Slice(distinct, func(i, j int) bool { return distinct[i].After(distinct[j]) })
+	cutoff := distinct[min(days, len(distinct))-1]
+	for name, day := range indexDays {
+		if !day.Before(cutoff) {
+			retained[name] = true
+		}
+	}
+	return retained
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Mapping of the --catalog-index, created when the index doesn't exist yet
+const catalogMapping = `{
+	"mappings": {
+		"properties": {
+			"snapshot":      { "type": "keyword" },
+			"index":         { "type": "keyword" },
+			"repository":    { "type": "keyword" },
+			"tags":          { "type": "keyword" },
+			"min_timestamp": { "type": "date" },
+			"max_timestamp": { "type": "date" },
+			"created_at":    { "type": "date" }
+		}
+	}
+}`
+
+// One created snapshot as recorded in the catalog index
+type catalogEntry struct {
+	Snapshot     string     `json:"snapshot"`
+	Index        string     `json:"index"`
+	Repository   string     `json:"repository"`
+	Tags         []string   `json:"tags,omitempty"`
+	MinTimestamp *time.Time `json:"min_timestamp,omitempty"`
+	MaxTimestamp *time.Time `json:"max_timestamp,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Records every created snapshot in an index of the same cluster, so the
+// archive can be searched without listing the repository
+type snapshotCatalog struct {
+	index string
+	tags  []string
+}
+
+// Create the catalog index with its mapping unless it already exists
+func (c *snapshotCatalog) ensureIndex(ctx context.Context, client *clusterClient) error {
+	exists := opensearchapi.IndicesExistsRequest{Index: []string{c.index}}
+	res, err := exists.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	if res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to check catalog index: %s", res.Status())
+	}
+
+	req := opensearchapi.IndicesCreateRequest{
+		Index: c.index,
+		Body:  bytes.NewReader([]byte(catalogMapping)),
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err = req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to create catalog index: %s", res.String())
+	}
+	log.Printf("Created catalog index %s", c.index)
+	return nil
+}
+
+// Write the catalog record of a snapshot, keyed by repository and snapshot name
+func (c *snapshotCatalog) record(ctx context.Context, client *clusterClient, entry catalogEntry) error {
+	entry.Tags = c.tags
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	req := opensearchapi.IndexRequest{
+		Index:      c.index,
+		DocumentID: entry.Repository + ":" + entry.Snapshot,
+		Body:       bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to write catalog record: %s", res.String())
+	}
+	return nil
+}
+
+// Record a snapshot in the catalog, if any, only warning when that fails
+func (a *archiver) catalogSnapshot(ctx context.Context, l *indexLogger, repo, index, snapshot string, timestamps *timestampRange) {
+	if a.catalog == nil {
+		return
+	}
+
+	entry := catalogEntry{Snapshot: snapshot, Index: index, Repository: repo, CreatedAt: time.Now().UTC()}
+	if timestamps != nil {
+		entry.MinTimestamp, entry.MaxTimestamp = &timestamps.Min, &timestamps.Max
+	}
+	if err := a.catalog.record(ctx, a.client, entry); err != nil {
+		l.Printf("Warning: could not record snapshot %s in catalog index %s: %s", snapshot, a.catalog.index, err)
+	}
+}
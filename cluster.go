@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Wait until the cluster, or only the index when one is given, reports at
+// least the given health status
+func waitForClusterHealth(ctx context.Context, l *indexLogger, client *clusterClient, index, status string, timeout time.Duration) error {
+	req := opensearchapi.ClusterHealthRequest{
+		WaitForStatus: status,
+		Timeout:       timeout,
+	}
+	subject := "the cluster"
+	if index != "" {
+		req.Index = []string{index}
+		subject = "index " + index
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// The cluster answers 408 when the status wasn't reached within the timeout
+	if res.IsError() && res.StatusCode != http.StatusRequestTimeout {
+		return fmt.Errorf("failed to get health of %s: %s", subject, res.String())
+	}
+
+	var health struct {
+		Status   string `json:"status"`
+		TimedOut bool   `json:"timed_out"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		return err
+	}
+
+	l.Printf("Health of %s is %s (required: %s)", subject, health.Status, status)
+	if health.TimedOut {
+		return fmt.Errorf("%s did not reach %s health within %s (current: %s)", subject, status, timeout, health.Status)
+	}
+	return nil
+}
+
+// Normalize the OpenSearch URL: default to http when no scheme is given,
+// only accept http and https, and strip trailing slashes
+func normalizeURL(raw string, hasCredentials bool) (string, error) {
+	if !strings.Contains(raw, "://") {
+		log.Printf("No scheme in --url %q, assuming http://", raw)
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme %q, expected http or https", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("query strings and fragments are not supported")
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	// Secured clusters normally sit behind https, warn before credentials leak in clear text
+	switch {
+	case u.Scheme == "http" && (u.User != nil || hasCredentials):
+		log.Printf("Warning: --url uses plain http, credentials will be sent in clear text (%s)", u.Redacted())
+	case u.Scheme == "http" && u.Port() == "443":
+		log.Printf("Warning: --url uses plain http against what looks like a secured cluster (%s)", u.Redacted())
+	}
+	return u.String(), nil
+}
+
+// Backoff bounds while waiting for the pending cluster tasks queue to drain
+const (
+	pendingTasksInitialBackoff = 5 * time.Second
+	pendingTasksMaxBackoff     = time.Minute
+)
+
+// Block until the cluster has at most maxPending pending tasks
+func waitForPendingTasks(ctx context.Context, l *indexLogger, client *clusterClient, maxPending int) error {
+	backoff := pendingTasksInitialBackoff
+	backedOff := false
+	for {
+		pending, err := countPendingTasks(ctx, client)
+		if err != nil {
+			return err
+		}
+		if pending <= maxPending {
+			if backedOff {
+				l.Printf("Pending cluster tasks down to %d, resuming", pending)
+			}
+			return nil
+		}
+
+		l.Printf("%d pending cluster tasks exceeds --max-pending-tasks %d, backing off for %s", pending, maxPending, backoff)
+		backedOff = true
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, pendingTasksMaxBackoff)
+	}
+}
+
+func countPendingTasks(ctx context.Context, client *clusterClient) (int, error) {
+	req := opensearchapi.ClusterPendingTasksRequest{}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("failed to get pending cluster tasks: %s", res.String())
+	}
+
+	var result struct {
+		Tasks []json.RawMessage `json:"tasks"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return len(result.Tasks), nil
+}
+
+// How often to re-check unassigned shards while waiting for reassignment
+const unassignedPollInterval = 15 * time.Second
+
+// Whether a snapshot create failed because some primary shards are unavailable
+func isUnassignedPrimaryError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "have primary shards")
+}
+
+// Wait until every primary shard of the index is assigned, logging the
+// unassigned shards and their reasons while waiting
+func waitForAssignedPrimaries(ctx context.Context, l *indexLogger, client *clusterClient, index string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		unassigned, err := unassignedPrimaries(ctx, client, index)
+		if err != nil {
+			return err
+		}
+		if len(unassigned) == 0 {
+			return nil
+		}
+
+		for _, shard := range unassigned {
+			l.Printf("Index %s primary shard %s is unassigned: %s", index, shard.Shard, shard.Reason)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d primary shards of index %s still unassigned after %s", len(unassigned), index, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(unassignedPollInterval):
+		}
+	}
+}
+
+// An unassigned shard as reported by the cat shards API
+type unassignedShard struct {
+	Shard  string `json:"shard"`
+	Prirep string `json:"prirep"`
+	State  string `json:"state"`
+	Reason string `json:"unassigned.reason"`
+}
+
+func unassignedPrimaries(ctx context.Context, client *clusterClient, index string) ([]unassignedShard, error) {
+	res, err := client.Cat.Shards(
+		client.Cat.Shards.WithContext(ctx),
+		client.Cat.Shards.WithIndex(index),
+		client.Cat.Shards.WithFormat("json"),
+		client.Cat.Shards.WithH("shard", "prirep", "state", "unassigned.reason"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to get shards: %s", res.String())
+	}
+
+	var shards []unassignedShard
+	if err := json.NewDecoder(res.Body).Decode(&shards); err != nil {
+		return nil, err
+	}
+
+	var unassigned []unassignedShard
+	for _, shard := range shards {
+		if shard.Prirep == "p" && shard.State == "UNASSIGNED" {
+			unassigned = append(unassigned, shard)
+		}
+	}
+	return unassigned, nil
+}
+
+// Measure how far the local clock is from the cluster's, using the Date
+// header of a cluster info request. The header only has second precision.
+func measureClockSkew(ctx context.Context, client *clusterClient) (time.Duration, error) {
+	start := time.Now()
+	req := opensearchapi.InfoRequest{}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	elapsed := time.Since(start)
+
+	if res.IsError() {
+		return 0, fmt.Errorf("failed to get cluster info: %s", res.String())
+	}
+
+	date := res.Header.Get("Date")
+	if date == "" {
+		return 0, fmt.Errorf("cluster response has no Date header")
+	}
+	clusterTime, err := http.ParseTime(date)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header %q: %s", date, err)
+	}
+
+	// Compare against the middle of the round trip, when the cluster most likely answered
+	return start.Add(elapsed / 2).Sub(clusterTime), nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// How often to poll the reindex task for progress
+const reindexPollInterval = 10 * time.Second
+
+// Values available to the --consolidate-target template
+type consolidateTemplateData struct {
+	First   string    // Oldest source index
+	Last    string    // Newest source index
+	Pattern string    // Indices pattern passed with --pattern
+	Now     time.Time // Time the run started
+}
+
+// Render the consolidated index name from the target template
+func renderConsolidateTarget(tmpl string, data consolidateTemplateData) (string, error) {
+	t, err := template.New("consolidate-target").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	target := buf.String()
+	if target == "" {
+		return "", fmt.Errorf("template rendered an empty index name")
+	}
+	if target != strings.ToLower(target) {
+		return "", fmt.Errorf("index name %q must be lowercase", target)
+	}
+	return target, nil
+}
+
+// Whether the index pattern, in the comma separated form the cat API takes,
+// matches the index. Parts starting with - exclude what earlier parts matched.
+func matchesIndexPattern(pattern, index string) bool {
+	matched := false
+	for _, part := range strings.Split(pattern, ",") {
+		part = strings.TrimSpace(part)
+		exclude := strings.HasPrefix(part, "-")
+		glob := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimPrefix(part, "-")), `\*`, ".*") + "$"
+		if regexp.MustCompile(glob).MatchString(index) {
+			matched = !exclude
+		}
+	}
+	return matched
+}
+
+// Index settings that describe a particular index rather than how to build one,
+// or that would block writes into the target. Matched as flat setting prefixes.
+var unclonableIndexSettings = []string{
+	"index.uuid",
+	"index.version.",
+	"index.creation_date",
+	"index.provided_name",
+	"index.routing.",
+	"index.resize.",
+	"index.blocks.",
+	"index.verified_before_close",
+	"index.history.uuid",
+}
+
+// Create the target with the mappings and settings of the first source, so the
+// reindexed documents keep their field types instead of getting dynamic mappings
+func createConsolidatedIndex(ctx context.Context, l *indexLogger, client *clusterClient, source, target string) error {
+	exists := opensearchapi.IndicesExistsRequest{Index: []string{target}}
+	existsRes, err := exists.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	existsRes.Body.Close()
+	if existsRes.StatusCode != http.StatusNotFound {
+		l.Printf("Consolidated index %s already exists, reindexing into it", target)
+		return nil
+	}
+
+	flatSettings := true
+	getReq := opensearchapi.IndicesGetRequest{Index: []string{source}, FlatSettings: &flatSettings}
+	getReq.MasterTimeout, getReq.ClusterManagerTimeout = client.managerTimeout.values()
+	getRes, err := getReq.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer getRes.Body.Close()
+
+	if getRes.IsError() {
+		return fmt.Errorf("failed to get index %s: %s", source, getRes.String())
+	}
+
+	var indices map[string]struct {
+		Mappings json.RawMessage        `json:"mappings"`
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.NewDecoder(getRes.Body).Decode(&indices); err != nil {
+		return err
+	}
+	definition, ok := indices[source]
+	if !ok {
+		return fmt.Errorf("index %s not found", source)
+	}
+
+	settings := make(map[string]interface{})
+	for key, value := range definition.Settings {
+		if !slices.ContainsFunc(unclonableIndexSettings, func(prefix string) bool { return strings.HasPrefix(key, prefix) }) {
+			settings[key] = value
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"settings": settings,
+		"mappings": definition.Mappings,
+	})
+	if err != nil {
+		return err
+	}
+
+	createReq := opensearchapi.IndicesCreateRequest{Index: target, Body: bytes.NewReader(body)}
+	createReq.MasterTimeout, createReq.ClusterManagerTimeout = client.managerTimeout.values()
+	createRes, err := createReq.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer createRes.Body.Close()
+
+	if createRes.IsError() {
+		return fmt.Errorf("failed to create index %s: %s", target, createRes.String())
+	}
+	l.Printf("Created consolidated index %s with the mappings and settings of %s", target, source)
+	return nil
+}
+
+// Reindex all source indices into the target index and wait for the task to finish
+func reindexIndices(ctx context.Context, l *indexLogger, client *clusterClient, sources []string, target string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    map[string]interface{}{"index": sources},
+		"dest":      map[string]interface{}{"index": target, "op_type": "create"},
+	})
+	if err != nil {
+		return err
+	}
+
+	waitForCompletion := false
+	req := opensearchapi.ReindexRequest{
+		Body:              bytes.NewReader(body),
+		WaitForCompletion: &waitForCompletion,
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to start reindex: %s", res.String())
+	}
+
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&started); err != nil {
+		return err
+	}
+
+	l.Printf("Reindex task %s started for %d indices into %s", started.Task, len(sources), target)
+	return waitForReindexTask(ctx, l, client, started.Task)
+}
+
+// Poll the reindex task until it completes, logging progress along the way
+func waitForReindexTask(ctx context.Context, l *indexLogger, client *clusterClient, taskID string) error {
+	for {
+		req := opensearchapi.TasksGetRequest{TaskID: taskID}
+		res, err := req.Do(ctx, client)
+		if err != nil {
+			return err
+		}
+
+		if res.IsError() {
+			err := fmt.Errorf("failed to get reindex task %s: %s", taskID, res.String())
+			res.Body.Close()
+			return err
+		}
+
+		var result struct {
+			Completed bool `json:"completed"`
+			Task      struct {
+				Status struct {
+					Total   int64 `json:"total"`
+					Created int64 `json:"created"`
+				} `json:"status"`
+			} `json:"task"`
+			Response struct {
+				Failures []json.RawMessage `json:"failures"`
+			} `json:"response"`
+			Error json.RawMessage `json:"error"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		status := result.Task.Status
+		if result.Completed {
+			if len(result.Error) > 0 {
+				return fmt.Errorf("reindex task %s failed: %s", taskID, result.Error)
+			}
+			if len(result.Response.Failures) > 0 {
+				return fmt.Errorf("reindex task %s finished with %d failures, first: %s", taskID, len(result.Response.Failures), result.Response.Failures[0])
+			}
+			l.Printf("Reindex task %s completed: %d/%d documents created", taskID, status.Created, status.Total)
+			return nil
+		}
+
+		l.Printf("Reindex task %s in progress: %d/%d documents created", taskID, status.Created, status.Total)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reindexPollInterval):
+		}
+	}
+}
+
+// Count documents across the given indices
+func countDocuments(ctx context.Context, client *clusterClient, indices []string) (int64, error) {
+	req := opensearchapi.CountRequest{Index: indices}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("failed to count documents: %s", res.String())
+	}
+
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// Refresh the index so that freshly reindexed documents are counted
+func refreshIndex(ctx context.Context, client *clusterClient, index string) error {
+	req := opensearchapi.IndicesRefreshRequest{Index: []string{index}}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to refresh index: %s", res.String())
+	}
+	return nil
+}
+
+// Reindex sources into the target and verify that no documents went missing
+func consolidateIndices(ctx context.Context, l *indexLogger, client *clusterClient, sources []string, target string) error {
+	sourceDocs, err := countDocuments(ctx, client, sources)
+	if err != nil {
+		return err
+	}
+
+	if err := createConsolidatedIndex(ctx, l, client, sources[0], target); err != nil {
+		return err
+	}
+	if err := reindexIndices(ctx, l, client, sources, target); err != nil {
+		return err
+	}
+	if err := refreshIndex(ctx, client, target); err != nil {
+		return err
+	}
+
+	targetDocs, err := countDocuments(ctx, client, []string{target})
+	if err != nil {
+		return err
+	}
+	if targetDocs < sourceDocs {
+		return fmt.Errorf("consolidated index %s has %d documents, expected at least %d", target, targetDocs, sourceDocs)
+	}
+
+	l.Printf("Consolidated %d indices (%d documents) into %s", len(sources), sourceDocs, target)
+	return nil
+}
+
+func deleteIndex(ctx context.Context, client *clusterClient, index string) error {
+	req := opensearchapi.IndicesDeleteRequest{Index: []string{index}}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to delete index: %s", res.String())
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestMatchesIndexPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		index   string
+		want    bool
+	}{
+		{pattern: "uat_*", index: "uat_archive_202410", want: true},
+		{pattern: "uat_*", index: "archive_uat_202410", want: false},
+		{pattern: "uat_1", index: "uat_1", want: true},
+		{pattern: "uat_1", index: "uat_10", want: false},
+		{pattern: "uat.*", index: "uat_1", want: false},
+		{pattern: "prod_*, uat_*", index: "uat_archive", want: true},
+		{pattern: "uat_*,-uat_archive_*", index: "uat_archive_202410", want: false},
+		{pattern: "uat_*,-uat_archive_*", index: "uat_42", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchesIndexPattern(tt.pattern, tt.index); got != tt.want {
+			t.Errorf("matchesIndexPattern(%q, %q) = %v, want %v", tt.pattern, tt.index, got, tt.want)
+		}
+	}
+}
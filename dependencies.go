@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// For each index, the indices that must be snapshotted before it
+type dependencyMap map[string][]string
+
+// Load the dependency map from a JSON file
+func loadDependencies(path string) (dependencyMap, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dependencies dependencyMap
+	if err := json.Unmarshal(data, &dependencies); err != nil {
+		return nil, fmt.Errorf("invalid dependency file %s: %s", path, err)
+	}
+	return dependencies, nil
+}
+
+// Reorder indices so that every index comes after the indices it depends on.
+// Independent indices keep their current relative order, and dependencies on
+// indices that are not being archived are ignored.
+func orderByDependencies(indices []IndexInfo, dependencies dependencyMap) ([]IndexInfo, error) {
+	position := make(map[string]int, len(indices))
+	for i, index := range indices {
+		position[index.Name] = i
+	}
+
+	// Count unresolved dependencies and remember who waits on whom
+	pending := make([]int, len(indices))
+	dependents := make([][]int, len(indices))
+	for i, index := range indices {
+		for _, dependency := range dependencies[index.Name] {
+			j, ok := position[dependency]
+			if !ok || j == i {
+				continue
+			}
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i := range indices {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	ordered := make([]IndexInfo, 0, len(indices))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, indices[i])
+
+		for _, dependent := range dependents[i] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(ordered) < len(indices) {
+		var cycle []string
+		for i, index := range indices {
+			if pending[i] > 0 {
+				cycle = append(cycle, index.Name)
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle between indices: %s", strings.Join(cycle, ", "))
+	}
+	return ordered, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Environment variables backing flags that aren't set on the command line
+var flagEnvVars = map[string]string{
+	"url":      "OPENSEARCH_URL",
+	"username": "OPENSEARCH_USERNAME",
+	"password": "OPENSEARCH_PASSWORD",
+}
+
+// Valid environment variable names in an env file
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Parse a dotenv file of KEY=VALUE lines. Blank lines and lines starting with #
+// are ignored, an optional "export " prefix and matching quotes around the
+// value are stripped.
+func parseEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !found || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE, got %q", path, lineNo, line)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			if value[len(value)-1] != value[0] {
+				return nil, fmt.Errorf("%s:%d: unterminated quoted value for %s", path, lineNo, key)
+			}
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Load the env file into the process environment, without overriding
+// variables that are already set
+func loadEnvFile(path string) error {
+	env, err := parseEnvFile(path)
+	if err != nil {
+		return err
+	}
+	for key, value := range env {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fill flags that weren't given on the command line from their environment variable
+func applyFlagEnvVars(flags *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, envVar := range flagEnvVars {
+		value := os.Getenv(envVar)
+		if explicit[name] || value == "" || flags.Lookup(name) == nil {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s: %s", envVar, err)
+		}
+	}
+	return nil
+}
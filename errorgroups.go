@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// How many index names to show for each error group
+const errorGroupSamples = 5
+
+var numberPattern = regexp.MustCompile(`\d+`)
+
+// Reduce an error message to its shape so that the same failure on different
+// indices compares equal: drop the index name and any numbers
+func normalizeError(failure indexFailure) string {
+	message := strings.ReplaceAll(failure.Err.Error(), failure.Index, "<index>")
+	message = numberPattern.ReplaceAllString(message, "N")
+	return fmt.Sprintf("%s: %s", failure.Step, message)
+}
+
+// Log failures grouped by their normalized message, largest group first
+func logErrorGroups(failures []indexFailure) {
+	if len(failures) == 0 {
+		return
+	}
+
+	groups := make(map[string][]string)
+	for _, failure := range failures {
+		key := normalizeError(failure)
+		groups[key] = append(groups[key], failure.Index)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	log.Printf("%d failures in %d distinct groups:", len(failures), len(groups))
+	for _, key := range keys {
+		indices := groups[key]
+		samples := indices[:min(len(indices), errorGroupSamples)]
+		more := ""
+		if len(indices) > len(samples) {
+			more = fmt.Sprintf(" and %d more", len(indices)-len(samples))
+		}
+		log.Printf("  %d x %s (e.g. %s%s)", len(indices), key, strings.Join(samples, ", "), more)
+	}
+}
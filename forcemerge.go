@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// How --force-merge-before merges indices ahead of their snapshot
+type forceMerge struct {
+	maxNumSegments int
+	timeout        time.Duration
+}
+
+// Make the index read-only and merge it down to at most maxNumSegments segments
+func (m *forceMerge) run(ctx context.Context, l *indexLogger, client *clusterClient, index string) error {
+	if err := addWriteBlock(ctx, l, client, index); err != nil {
+		return err
+	}
+
+	l.Printf("Force-merging index %s to %d segments, this can take a long time", index, m.maxNumSegments)
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	req := opensearchapi.IndicesForcemergeRequest{
+		Index:          []string{index},
+		MaxNumSegments: &m.maxNumSegments,
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("force-merge of index %s did not finish within %s, it keeps running on the cluster", index, m.timeout)
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to force-merge index: %s", res.String())
+	}
+	l.Printf("Force-merged index %s in %s", index, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// Block writes to the index so that no new segments appear after the merge
+func addWriteBlock(ctx context.Context, l *indexLogger, client *clusterClient, index string) error {
+	req := opensearchapi.IndicesAddBlockRequest{
+		Index: []string{index},
+		Block: "write",
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to make index read-only: %s", res.String())
+	}
+	l.Printf("Index %s is now read-only", index)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Suffix of the alias Graylog points at the index set's current write index
+const deflectorAliasSuffix = "_deflector"
+
+// Find the indices Graylog is currently writing to, i.e. those behind a
+// <prefix>_deflector alias. Returns nothing for index sets without a deflector.
+func detectActiveIndices(ctx context.Context, client *clusterClient, pattern string) (map[string]string, error) {
+	req := opensearchapi.IndicesGetAliasRequest{
+		Index: []string{pattern},
+		Name:  []string{"*" + deflectorAliasSuffix},
+	}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// No matching alias is reported as 404
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to get aliases: %s", res.String())
+	}
+
+	var result map[string]struct {
+		Aliases map[string]json.RawMessage `json:"aliases"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	active := make(map[string]string)
+	for index, entry := range result {
+		for alias := range entry.Aliases {
+			active[index] = alias
+		}
+	}
+	return active, nil
+}
+
+// Drop the indices Graylog is writing to from those about to be archived,
+// logging the detected rotation strategy
+func excludeActiveIndices(ctx context.Context, client *clusterClient, pattern string, indices []IndexInfo) ([]IndexInfo, error) {
+	active, err := detectActiveIndices(ctx, client, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(active) == 0 {
+		log.Println("No Graylog deflector alias found, relying on --bypass to keep the active index")
+		return indices, nil
+	}
+
+	kept := make([]IndexInfo, 0, len(indices))
+	for _, index := range indices {
+		if alias, ok := active[index.Name]; ok {
+			log.Printf("Detected Graylog deflector strategy: %s is the active write index (alias %s), not archiving it", index.Name, alias)
+			continue
+		}
+		kept = append(kept, index)
+	}
+	return kept, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// Run a lifecycle hook through the system shell. The hook name is passed in
+// ARCHIVER_HOOK, and when a summary is given it is exposed both as
+// ARCHIVER_TOTAL/SUCCEEDED/SKIPPED/FAILED variables and as JSON on stdin.
+func runHook(ctx context.Context, command, hook string, summary *runSummary) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = log.Writer()
+	cmd.Env = append(os.Environ(), "ARCHIVER_HOOK="+hook)
+
+	if summary != nil {
+		cmd.Env = append(cmd.Env,
+			"ARCHIVER_TOTAL="+strconv.Itoa(summary.Total),
+			"ARCHIVER_SUCCEEDED="+strconv.Itoa(summary.Succeeded),
+			"ARCHIVER_SKIPPED="+strconv.Itoa(summary.Skipped),
+			"ARCHIVER_FAILED="+strconv.Itoa(summary.Failed),
+		)
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = bytes.NewReader(data)
+	}
+
+	return cmd.Run()
+}
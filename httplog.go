@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+)
+
+// HTTP transport that logs every request and response sent to OpenSearch.
+// Headers are never logged so that credentials don't end up in the output,
+// and any user info in the URL is redacted.
+type verboseHTTPTransport struct {
+	next http.RoundTripper
+}
+
+func (t *verboseHTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := drainBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[http] --> %s %s\n%s", req.Method, req.URL.Redacted(), reqBody)
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("[http] <-- %s %s error: %s", req.Method, req.URL.Redacted(), err)
+		return nil, err
+	}
+
+	resBody, err := drainBody(&res.Body)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	log.Printf("[http] <-- %s %s %s\n%s", req.Method, req.URL.Redacted(), res.Status, resBody)
+	return res, nil
+}
+
+// Read the whole body and replace it with an in-memory copy so it can still be consumed
+func drainBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
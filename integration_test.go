@@ -0,0 +1,213 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Image the integration tests run against
+const integrationImage = "opensearchproject/opensearch:2.11.1"
+
+// Start a single node OpenSearch with security disabled and an fs repository
+// registered as "backup"
+func startOpenSearch(t *testing.T) *clusterClient {
+	t.Helper()
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        integrationImage,
+			ExposedPorts: []string{"9200/tcp"},
+			Env: map[string]string{
+				"discovery.type":              "single-node",
+				"path.repo":                   "/tmp/snapshots",
+				"DISABLE_SECURITY_PLUGIN":     "true",
+				"DISABLE_INSTALL_DEMO_CONFIG": "true",
+				"OPENSEARCH_JAVA_OPTS":        "-Xms512m -Xmx512m",
+			},
+			WaitingFor: wait.ForHTTP("/_cluster/health").WithPort("9200/tcp").WithStartupTimeout(3 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("starting OpenSearch: %s", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(ctx); err != nil {
+			t.Errorf("stopping OpenSearch: %s", err)
+		}
+	})
+
+	endpoint, err := container.PortEndpoint(ctx, "9200/tcp", "http")
+	if err != nil {
+		t.Fatalf("resolving OpenSearch endpoint: %s", err)
+	}
+	client, err := newClient(connectionOptions{address: endpoint})
+	if err != nil {
+		t.Fatalf("newClient: %s", err)
+	}
+
+	mustSucceed(t, client, "registering repository", opensearchapi.SnapshotCreateRepositoryRequest{
+		Repository: "backup",
+		Body:       strings.NewReader(`{"type": "fs", "settings": {"location": "/tmp/snapshots"}}`),
+	})
+	return client
+}
+
+// Run the request and fail the test on transport or API errors
+func mustSucceed(t *testing.T, client *clusterClient, what string, req opensearchapi.Request) {
+	t.Helper()
+	res, err := req.Do(context.Background(), client)
+	if err != nil {
+		t.Fatalf("%s: %s", what, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		t.Fatalf("%s: %s", what, res.String())
+	}
+}
+
+// Create an index with Graylog's timestamp mapping holding one document per timestamp
+func createTimestampedIndex(t *testing.T, client *clusterClient, index string, timestamps ...string) {
+	t.Helper()
+
+	mustSucceed(t, client, "creating "+index, opensearchapi.IndicesCreateRequest{
+		Index: index,
+		Body: strings.NewReader(`{
+			"settings": {"number_of_shards": 1, "number_of_replicas": 0},
+			"mappings": {"properties": {"timestamp": {"type": "date", "format": "yyyy-MM-dd HH:mm:ss.SSS"}}}
+		}`),
+	})
+
+	var bulk strings.Builder
+	for _, ts := range timestamps {
+		fmt.Fprintf(&bulk, "{\"index\": {}}\n{\"timestamp\": %q, \"message\": \"test\"}\n", ts)
+	}
+	if bulk.Len() > 0 {
+		mustSucceed(t, client, "indexing into "+index, opensearchapi.BulkRequest{
+			Index:   index,
+			Body:    strings.NewReader(bulk.String()),
+			Refresh: "true",
+		})
+	}
+}
+
+func TestIntegrationArchiveIndices(t *testing.T) {
+	client := startOpenSearch(t)
+	ctx := context.Background()
+
+	createTimestampedIndex(t, client, "uat_1", "2024-01-01 00:00:00.000", "2024-01-31 23:59:59.000")
+	createTimestampedIndex(t, client, "uat_2", "2024-02-01 08:30:00.000", "2024-02-29 17:45:00.000")
+	createTimestampedIndex(t, client, "uat_3")
+
+	// OpenSearch reads the timestamps as UTC, snapshot names use local time
+	analyzed := strings.Join([]string{
+		"uat_2",
+		time.Date(2024, 2, 1, 8, 30, 0, 0, time.UTC).Local().Format(snapshotTimeFormat),
+		time.Date(2024, 2, 29, 17, 45, 0, 0, time.UTC).Local().Format(snapshotTimeFormat),
+	}, ".")
+
+	tests := []struct {
+		name    string
+		analyze bool
+		index   string
+		want    string
+	}{
+		{name: "plain name", index: "uat_1", want: "uat_1"},
+		{name: "analyzed", analyze: true, index: "uat_2", want: analyzed},
+		{name: "analyzed without timestamps", analyze: true, index: "uat_3", want: "uat_3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indices, err := getIndices(ctx, client, tt.index)
+			if err != nil {
+				t.Fatalf("getIndices() error = %v", err)
+			}
+
+			arch := &archiver{
+				client: client,
+				repo:   "backup",
+				naming: snapshotNaming{
+					analyze:        tt.analyze,
+					separator:      ".",
+					missingPolicy:  "plain-name",
+					lowercase:      true,
+					timeFormat:     snapshotTimeFormat,
+					timestampField: "timestamp",
+				},
+				maxPendingTasks: 1,
+				wait:            true,
+				concurrency:     1,
+				waitTimeout:     time.Minute,
+				includeAliases:  true,
+			}
+			summary := arch.archiveIndices(ctx, indices)
+			if summary.Succeeded != 1 {
+				t.Fatalf("archiveIndices() = %+v, want the index to succeed", summary)
+			}
+
+			info, err := getSnapshotInfo(ctx, client, "backup", tt.want)
+			if err != nil {
+				t.Fatalf("getSnapshotInfo(%s) error = %v", tt.want, err)
+			}
+			if info.State != "SUCCESS" || len(info.Indices) != 1 || info.Indices[0] != tt.index {
+				t.Errorf("snapshot %s = %+v, want a successful snapshot of %s", tt.want, info, tt.index)
+			}
+
+			// A second run finds the snapshot and leaves it alone
+			summary = arch.archiveIndices(ctx, indices)
+			if summary.Skipped != 1 {
+				t.Errorf("second archiveIndices() = %+v, want the index to be skipped", summary)
+			}
+		})
+	}
+}
+
+func TestIntegrationDeleteAfterSnapshot(t *testing.T) {
+	client := startOpenSearch(t)
+	ctx := context.Background()
+
+	createTimestampedIndex(t, client, "uat_1", "2024-01-01 00:00:00.000")
+	indices, err := getIndices(ctx, client, "uat_1")
+	if err != nil {
+		t.Fatalf("getIndices() error = %v", err)
+	}
+
+	arch := &archiver{
+		client:              client,
+		repo:                "backup",
+		naming:              snapshotNaming{separator: ".", missingPolicy: "plain-name", lowercase: true, timeFormat: snapshotTimeFormat, timestampField: "timestamp"},
+		maxPendingTasks:     1,
+		wait:                true,
+		deleteAfterSnapshot: true,
+		concurrency:         1,
+		waitTimeout:         time.Minute,
+		includeAliases:      true,
+		deleteHealthStatus:  "green",
+		deleteHealthTimeout: time.Minute,
+	}
+	summary := arch.archiveIndices(ctx, indices)
+	if summary.Succeeded != 1 {
+		t.Fatalf("archiveIndices() = %+v, want the index to succeed", summary)
+	}
+
+	if _, err := getSnapshotInfo(ctx, client, "backup", "uat_1"); err != nil {
+		t.Fatalf("getSnapshotInfo(uat_1) error = %v", err)
+	}
+	remaining, err := getIndices(ctx, client, "uat_*")
+	if err != nil {
+		t.Fatalf("getIndices() error = %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("indices left after --delete = %+v, want none", remaining)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+)
+
+// One index in the inventory report
+type inventoryRecord struct {
+	Index        string    `json:"index"`
+	Health       string    `json:"health"`
+	Status       string    `json:"status"`
+	DocsCount    int64     `json:"docs_count"`
+	StoreSize    int64     `json:"store_size_bytes"`
+	CreationDate time.Time `json:"creation_date"`
+	MinTimestamp string    `json:"min_timestamp,omitempty"`
+	MaxTimestamp string    `json:"max_timestamp,omitempty"`
+}
+
+func (inventoryRecord) csvHeader() []string {
+	return []string{"index", "health", "status", "docs_count", "store_size_bytes", "creation_date", "min_timestamp", "max_timestamp"}
+}
+
+func (r inventoryRecord) csvRow() []string {
+	return []string{
+		r.Index,
+		r.Health,
+		r.Status,
+		strconv.FormatInt(r.DocsCount, 10),
+		strconv.FormatInt(r.StoreSize, 10),
+		r.CreationDate.Format(time.RFC3339),
+		r.MinTimestamp,
+		r.MaxTimestamp,
+	}
+}
+
+// Write an inventory of all indices matching the pattern without snapshotting anything
+func writeInventory(ctx context.Context, client *clusterClient, pattern string, analyze bool, timestampField, path, format string) error {
+	indices, err := getIndices(ctx, client, pattern)
+	if err != nil {
+		return err
+	}
+
+	records := make([]inventoryRecord, len(indices))
+	for i, index := range indices {
+		records[i] = inventoryRecord{
+			Index:        index.Name,
+			Health:       index.Health,
+			Status:       index.Status,
+			DocsCount:    index.DocsCount,
+			StoreSize:    index.StoreSize,
+			CreationDate: index.CreationDate,
+		}
+
+		if analyze {
+			timestamps, err := analyzeTimestamps(ctx, client, index.Name, timestampField)
+			if err != nil {
+				log.Printf("Error analyzing timestamps for index %s: %s", index.Name, err)
+				continue
+			}
+			if !timestamps.Missing {
+				records[i].MinTimestamp = timestamps.Min.Format(time.RFC3339)
+				records[i].MaxTimestamp = timestamps.Max.Format(time.RFC3339)
+			}
+		}
+	}
+
+	if err := writeReport(path, format, records); err != nil {
+		return err
+	}
+	log.Printf("Wrote inventory of %d indices to %s", len(records), path)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Resolves the snapshot repository of an index from the snapshot action of its
+// ISM policy. Policy lookups are cached since many indices share a policy.
+type ismRepoResolver struct {
+	client *clusterClient
+
+	mu       sync.Mutex
+	policies map[string]string // Policy ID -> repository, empty when the policy has no snapshot action
+}
+
+func newISMRepoResolver(client *clusterClient) *ismRepoResolver {
+	return &ismRepoResolver{client: client, policies: make(map[string]string)}
+}
+
+// Repository the index's ISM policy snapshots to, or an empty string when the
+// index isn't managed or its policy has no snapshot action
+func (r *ismRepoResolver) resolve(ctx context.Context, index string) (string, error) {
+	policyID, err := r.policyID(ctx, index)
+	if err != nil || policyID == "" {
+		return "", err
+	}
+
+	r.mu.Lock()
+	repo, ok := r.policies[policyID]
+	r.mu.Unlock()
+	if ok {
+		return repo, nil
+	}
+
+	repo, err = r.policyRepository(ctx, policyID)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.policies[policyID] = repo
+	r.mu.Unlock()
+	return repo, nil
+}
+
+// ID of the ISM policy managing the index, empty when it isn't managed
+func (r *ismRepoResolver) policyID(ctx context.Context, index string) (string, error) {
+	var explain map[string]json.RawMessage
+	if err := r.get(ctx, "/_plugins/_ism/explain/"+url.PathEscape(index), &explain); err != nil {
+		return "", err
+	}
+
+	var state struct {
+		PolicyID string `json:"policy_id"`
+	}
+	if raw, ok := explain[index]; ok {
+		if err := json.Unmarshal(raw, &state); err != nil {
+			return "", err
+		}
+	}
+	return state.PolicyID, nil
+}
+
+// Repository of the first snapshot action in the policy
+func (r *ismRepoResolver) policyRepository(ctx context.Context, policyID string) (string, error) {
+	var result struct {
+		Policy struct {
+			States []struct {
+				Actions []struct {
+					Snapshot *struct {
+						Repository string `json:"repository"`
+					} `json:"snapshot"`
+				} `json:"actions"`
+			} `json:"states"`
+		} `json:"policy"`
+	}
+	if err := r.get(ctx, "/_plugins/_ism/policies/"+url.PathEscape(policyID), &result); err != nil {
+		return "", err
+	}
+
+	for _, state := range result.Policy.States {
+		for _, action := range state.Actions {
+			if action.Snapshot != nil && action.Snapshot.Repository != "" {
+				return action.Snapshot.Repository, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// GET an ISM plugin endpoint and decode its JSON response
+func (r *ismRepoResolver) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.client.Perform(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("[%d] %s", res.StatusCode, body)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Repository to snapshot the index into: the one from its ISM policy when
+// routing is enabled and found, --repo otherwise
+func (a *archiver) repoFor(ctx context.Context, l *indexLogger, index string) string {
+	if a.ismRepos == nil {
+		return a.repo
+	}
+
+	repo, err := a.ismRepos.resolve(ctx, index)
+	if err != nil {
+		l.Printf("Error reading ISM policy of index %s, using repository %s: %s", index, a.repo, err)
+		return a.repo
+	}
+	if repo == "" {
+		return a.repo
+	}
+	return repo
+}
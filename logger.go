@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Prefixes log lines with the index being processed and its position in the
+// run, so lines stay attributable when the output of several indices interleaves
+type indexLogger struct {
+	prefix string
+}
+
+func newIndexLogger(seq, total int, index string) *indexLogger {
+	return &indexLogger{prefix: fmt.Sprintf("[%d/%d %s] ", seq, total, index)}
+}
+
+func (l *indexLogger) Printf(format string, v ...interface{}) {
+	log.Output(2, l.prefix+fmt.Sprintf(format, v...))
+}
@@ -1,50 +1,425 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
+	"net/http"
+	"os"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/opensearch-project/opensearch-go/v2"
 	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
 )
 
+// Default layout of the timestamps in analyzed snapshot names
+const snapshotTimeFormat = "20060102-1504"
+
+// Characters OpenSearch rejects in snapshot names
+const invalidSnapshotNameChars = `\/*?"<>| ,#`
+
+// Fatal errors always reach stderr, even when --silent discards normal logging
+var fatal = &fatalLogger{Logger: log.New(os.Stderr, "", log.LstdFlags)}
+
 func main() {
+	// archive is the default command and may be omitted
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "archive":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	// Define command-line flags
 	indicesPattern := flag.String("pattern", "", "Indices pattern (e.g., 'uat_*')")
-	opensearchURL := flag.String("url", "", "OpenSearch URL")
+	opensearchURL := flag.String("url", "", "OpenSearch URL (env OPENSEARCH_URL)")
+	username := flag.String("username", "", "Username for basic authentication (env OPENSEARCH_USERNAME)")
+	password := flag.String("password", "", "Password for basic authentication (env OPENSEARCH_PASSWORD)")
+	caCert := flag.String("ca-cert", "", "PEM file with CA certificates to trust for https connections")
+	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "Don't verify the cluster's TLS certificate (unsafe, for testing only)")
 	numToBypass := flag.Int("bypass", 0, "Number of latest indices to bypass")
+	bypassDays := flag.Int("bypass-days", 0, "Also bypass all indices of the newest N distinct days, dated by index name or newest document")
+	bypassDaysUndated := flag.String("bypass-days-undated", "skip", "What --bypass-days does with indices without a date: skip or archive")
 	repoName := flag.String("repo", "", "Repository name in OpenSearch")
 	enableAnalyze := flag.Bool("analyze", false, "Enable min/max timestamp analysis for indices")
+	nameSeparator := flag.String("name-separator", ".", "Separator used when joining snapshot name components")
+	timestampField := flag.String("timestamp-field", "timestamp", "Field holding the document timestamps, used by --analyze")
+	nameFormat := flag.String("name-format", snapshotTimeFormat, "Go reference-time layout of the timestamps in analyzed snapshot names")
+	snapshotNameLowercase := flag.Bool("snapshot-name-lowercase", true, "Lowercase generated snapshot names, when false names are only validated")
+	requireTimestampField := flag.Bool("require-timestamp-field", false, "Shorthand for --analyze-missing-policy error")
+	analyzeMissingPolicy := flag.String("analyze-missing-policy", "plain-name", "With --analyze, how to name indices without timestamp values: skip, plain-name, error or epoch")
+	cleanupFailed := flag.Bool("cleanup-failed", false, "Delete FAILED snapshots matching the pattern before archiving")
+	confirm := flag.Bool("yes", false, "Confirm destructive operations")
+	dryRun := flag.Bool("dry-run", false, "Log what would be done without changing anything")
+	consolidate := flag.Bool("consolidate", false, "Reindex matched indices into a single archive index and snapshot that instead")
+	consolidateTarget := flag.String("consolidate-target", "", "Template for the consolidated index name (e.g., 'graylog_archive_{{.Now.Format \"200601\"}}')")
+	consolidateDeleteSources := flag.Bool("consolidate-delete-sources", false, "Delete source indices after they are consolidated and the snapshot is verified, implies --wait")
+	deleteHealthStatus := flag.String("delete-health-status", "", "Wait for the cluster to reach this health (yellow or green) before deleting indices")
+	deleteHealthTimeout := flag.Duration("delete-health-timeout", 5*time.Minute, "How long to wait for --delete-health-status before skipping deletion")
+	metadataLookupFile := flag.String("metadata-lookup-file", "", "JSON file mapping index names or prefixes to snapshot metadata")
+	orderBy := flag.String("order-by", "number", "Processing order of indices to archive: number, date, size or docs")
+	compatVersion := flag.String("compat-version", "auto", "OpenSearch major version whose request parameters to use: auto, 1 or 2")
+	managerTimeoutFlag := flag.Duration("cluster-manager-timeout", 0, "Timeout for connecting to the cluster-manager node on snapshot and settings requests")
+	inventoryFile := flag.String("inventory-file", "", "Write an inventory of matching indices to this file and exit without snapshotting")
+	inventoryFormat := flag.String("inventory-format", "json", "Format of the inventory file: json or csv")
+	analyzeOnly := flag.Bool("analyze-only", false, "Report the min/max timestamps of matching indices and exit without snapshotting")
+	analyzeOnlyFile := flag.String("analyze-only-file", "", "File to write the --analyze-only report to (default: stdout)")
+	analyzeOnlyFormat := flag.String("analyze-only-format", "json", "Format of the --analyze-only report: json or csv")
+	missingSnapshots := flag.Bool("missing-snapshots", false, "Report matching indices that no snapshot in the repository contains and exit without snapshotting")
+	missingSnapshotsFile := flag.String("missing-snapshots-file", "", "File to write the --missing-snapshots report to (default: stdout)")
+	missingSnapshotsFormat := flag.String("missing-snapshots-format", "json", "Format of the --missing-snapshots report: json or csv")
+	maxPendingTasks := flag.Int("max-pending-tasks", 0, "Back off before each snapshot while the cluster has more pending tasks than this (0 disables)")
+	expectRepoLocation := flag.String("expect-repo-location", "", "Refuse to run unless the repository stores its data here: bucket[/base_path], container[/base_path], or the fs location")
+	onStartExec := flag.String("on-start-exec", "", "Shell command to run before archiving starts, a non-zero exit aborts the run")
+	onFinishExec := flag.String("on-finish-exec", "", "Shell command to run after archiving finishes, receives the run summary")
+	failIfNoRepoAccess := flag.Bool("fail-if-no-repo-access", false, "Verify at startup that the repository exists and is writable from all nodes")
+	preflightTestSnapshot := flag.Bool("preflight-test-snapshot", false, "With --fail-if-no-repo-access, also write and delete an empty test snapshot")
+	dependencyFile := flag.String("dependency-file", "", "JSON file mapping index names to the indices that must be snapshotted before them")
+	snapshotRateLimit := flag.Duration("snapshot-rate-limit", 0, "Minimum time between two snapshot creations (0 disables)")
+	deepRestoreCheck := flag.Bool("deep-restore-check", false, "Restore new snapshots into a temporary index and compare document counts")
+	verifySampleRate := flag.Float64("verify-sample-rate", 1, "Fraction of new snapshots to run --deep-restore-check on (0-1)")
+	restoreCheckTimeout := flag.Duration("restore-check-timeout", 30*time.Minute, "How long --deep-restore-check waits for the snapshot and the restore to complete")
+	groupErrors := flag.Bool("group-errors", false, "At the end of the run, report failures grouped by error message")
+	repoFromISM := flag.Bool("repo-from-ism", false, "Snapshot each index into the repository of its ISM policy's snapshot action, falling back to --repo")
+	simulateFailureRate := flag.Float64("simulate-failure-rate", 0, "TESTING ONLY: fraction of snapshot creations to fail without contacting the cluster (0-1)")
+	simulateFailureSeed := flag.Int64("simulate-failure-seed", 1, "TESTING ONLY: random seed for --simulate-failure-rate")
+	detectActive := flag.Bool("detect-active-index", true, "Never archive the index behind a Graylog <prefix>_deflector alias")
+	silent := flag.Bool("silent", false, "Suppress all logging except fatal errors, report files are still written")
+	noPartial := flag.Bool("no-partial", false, "Send partial=false so a single unavailable shard fails the snapshot, then wait for reassignment and retry")
+	unassignedTimeout := flag.Duration("unassigned-timeout", 10*time.Minute, "How long --no-partial waits for unassigned primary shards before giving up")
+	allowlistFile := flag.String("allowlist-file", "", "File listing the exact index names allowed to be archived, one per line")
+	allowlistAllowMissing := flag.Bool("allowlist-allow-missing", false, "Only warn when the allowlist names indices that don't exist")
+	sortKeyRegex := flag.String("sort-key-regex", "", "Regular expression whose first capture group is the sort key of index names, instead of the trailing number")
+	sortKeyType := flag.String("sort-key-type", "number", "How --sort-key-regex keys compare: number or string")
+	waitForCompletion := flag.Bool("wait-for-completion", false, "Have the cluster hold each snapshot create until the snapshot finishes, logging progress meanwhile")
+	forceMergeBefore := flag.Bool("force-merge-before", false, "Make each index read-only and force-merge it before its snapshot (expensive)")
+	maxNumSegments := flag.Int("max-num-segments", 1, "Number of segments --force-merge-before merges each index down to")
+	forceMergeTimeout := flag.Duration("force-merge-timeout", time.Hour, "How long to wait for each --force-merge-before merge to finish")
+	includeAliases := flag.Bool("include-aliases", true, "Record in the snapshot metadata whether restores should bring back the index aliases")
+	catalogIndex := flag.String("catalog-index", "", "Record every created snapshot (name, index, range, tags) in this index of the cluster")
+	snapshotTags := flag.String("snapshot-tags", "", "Comma-separated tags stored with each --catalog-index record")
+	progressFile := flag.String("progress-file", "", "Atomically rewrite this file with the run progress as JSON whenever an index starts or finishes")
+	wait := flag.Bool("wait", false, "Wait for each snapshot to finish and count PARTIAL or FAILED snapshots as failures")
+	waitTimeout := flag.Duration("wait-timeout", 30*time.Minute, "How long --wait waits for each snapshot to finish")
+	deleteAfterSnapshot := flag.Bool("delete-after-snapshot", false, "Delete each index once its snapshot is verified SUCCESS and contains it, requires --yes")
+	concurrency := flag.Int("concurrency", 1, fmt.Sprintf("Number of indices archived in parallel (1-%d)", maxConcurrency))
+	doneFile := flag.String("done-file", "", "Atomically write the run summary to this file when the run completes without failures")
+	failFile := flag.String("fail-file", "", "Atomically write the run summary or error to this file when the run fails")
+	warnOnClockSkew := flag.Bool("warn-on-clock-skew", false, "At startup, warn when the local clock is more than --max-clock-skew away from the cluster's")
+	failOnClockSkew := flag.Bool("fail-on-clock-skew", false, "At startup, abort when the local clock is more than --max-clock-skew away from the cluster's")
+	maxClockSkew := flag.Duration("max-clock-skew", time.Minute, "Largest accepted difference between the local and the cluster clock")
+	envFile := flag.String("env-file", "", "Load environment variables from this dotenv file, variables already set take precedence")
+	verboseHTTP := flag.Bool("verbose-http", false, "Log every OpenSearch request and response body (very noisy, for debugging only)")
 
 	flag.Parse()
 
+	if *silent {
+		log.SetOutput(io.Discard)
+	}
+	fatal.failFile = *failFile
+
+	// Command-line flags take precedence over the environment, which takes precedence over --env-file
+	if *envFile != "" {
+		if err := loadEnvFile(*envFile); err != nil {
+			fatal.Fatalf("Error loading --env-file: %s", err)
+		}
+	}
+	if err := applyFlagEnvVars(flag.CommandLine); err != nil {
+		fatal.Fatalf("Error reading flags from the environment: %s", err)
+	}
+
 	// Validate inputs
-	if *indicesPattern == "" || *opensearchURL == "" || *repoName == "" {
-		log.Fatalf("Missing required arguments. Use --help for usage instructions.")
+	if *indicesPattern == "" || *opensearchURL == "" || (*repoName == "" && *inventoryFile == "" && !*analyzeOnly) {
+		fatal.Fatalf("Missing required arguments. Use --help for usage instructions.")
+	}
+	if !slices.Contains(reportFormats, *inventoryFormat) {
+		fatal.Fatalf("Invalid --inventory-format %q, expected one of: %s", *inventoryFormat, strings.Join(reportFormats, ", "))
+	}
+	if err := validateNameSeparator(*nameSeparator); err != nil {
+		fatal.Fatalf("Invalid --name-separator: %s", err)
+	}
+	if err := validateNameFormat(*nameFormat); err != nil {
+		fatal.Fatalf("Invalid --name-format: %s", err)
+	}
+	if *timestampField == "" {
+		fatal.Fatalf("--timestamp-field must not be empty.")
+	}
+	if !slices.Contains(reportFormats, *analyzeOnlyFormat) {
+		fatal.Fatalf("Invalid --analyze-only-format %q, expected one of: %s", *analyzeOnlyFormat, strings.Join(reportFormats, ", "))
+	}
+	if !slices.Contains(reportFormats, *missingSnapshotsFormat) {
+		fatal.Fatalf("Invalid --missing-snapshots-format %q, expected one of: %s", *missingSnapshotsFormat, strings.Join(reportFormats, ", "))
+	}
+	if !slices.Contains(orderByValues, *orderBy) {
+		fatal.Fatalf("Invalid --order-by %q, expected one of: %s", *orderBy, strings.Join(orderByValues, ", "))
+	}
+	if !slices.Contains(compatVersions, *compatVersion) {
+		fatal.Fatalf("Invalid --compat-version %q, expected one of: %s", *compatVersion, strings.Join(compatVersions, ", "))
+	}
+	if *bypassDays < 0 {
+		fatal.Fatalf("Invalid --bypass-days %d, expected a positive number of days", *bypassDays)
+	}
+	if !slices.Contains(undatedPolicies, *bypassDaysUndated) {
+		fatal.Fatalf("Invalid --bypass-days-undated %q, expected one of: %s", *bypassDaysUndated, strings.Join(undatedPolicies, ", "))
+	}
+	if !slices.Contains(missingTimestampPolicies, *analyzeMissingPolicy) {
+		fatal.Fatalf("Invalid --analyze-missing-policy %q, expected one of: %s", *analyzeMissingPolicy, strings.Join(missingTimestampPolicies, ", "))
+	}
+	if *requireTimestampField {
+		if *analyzeMissingPolicy != "plain-name" && *analyzeMissingPolicy != "error" {
+			fatal.Fatalf("--require-timestamp-field conflicts with --analyze-missing-policy %s.", *analyzeMissingPolicy)
+		}
+		*analyzeMissingPolicy = "error"
+	}
+	if !slices.Contains(sortKeyTypes, *sortKeyType) {
+		fatal.Fatalf("Invalid --sort-key-type %q, expected one of: %s", *sortKeyType, strings.Join(sortKeyTypes, ", "))
+	}
+	var nameSortKey *sortKey
+	if *sortKeyRegex != "" {
+		key, err := newSortKey(*sortKeyRegex, *sortKeyType)
+		if err != nil {
+			fatal.Fatalf("Invalid --sort-key-regex: %s", err)
+		}
+		nameSortKey = key
+	}
+	if *deleteHealthStatus != "" && *deleteHealthStatus != "yellow" && *deleteHealthStatus != "green" {
+		fatal.Fatalf("Invalid --delete-health-status %q, expected yellow or green", *deleteHealthStatus)
+	}
+	if *verifySampleRate < 0 || *verifySampleRate > 1 {
+		fatal.Fatalf("Invalid --verify-sample-rate %v, expected a value between 0 and 1", *verifySampleRate)
+	}
+	if *simulateFailureRate < 0 || *simulateFailureRate > 1 {
+		fatal.Fatalf("Invalid --simulate-failure-rate %v, expected a value between 0 and 1", *simulateFailureRate)
+	}
+	if *maxNumSegments < 1 {
+		fatal.Fatalf("Invalid --max-num-segments %d, expected at least 1", *maxNumSegments)
+	}
+	if *consolidate && *consolidateTarget == "" {
+		fatal.Fatalf("--consolidate requires --consolidate-target.")
+	}
+	if *consolidateDeleteSources && !*consolidate {
+		fatal.Fatalf("--consolidate-delete-sources requires --consolidate.")
+	}
+	if *concurrency < 1 || *concurrency > maxConcurrency {
+		fatal.Fatalf("Invalid --concurrency %d, expected a value between 1 and %d", *concurrency, maxConcurrency)
+	}
+	if *concurrency > 1 && *dependencyFile != "" {
+		fatal.Fatalf("--dependency-file needs indices archived one after another and can't be combined with --concurrency.")
+	}
+	if *deleteAfterSnapshot && *consolidate {
+		fatal.Fatalf("--delete-after-snapshot can't be combined with --consolidate, use --consolidate-delete-sources.")
+	}
+	if *consolidateDeleteSources && !*confirm && !*dryRun {
+		fatal.Fatalf("--consolidate-delete-sources deletes source indices and requires --yes.")
+	}
+
+	normalizedURL, err := normalizeURL(*opensearchURL, *username != "" || *password != "")
+	if err != nil {
+		fatal.Fatalf("Invalid --url %q: %s", *opensearchURL, err)
+	}
+
+	var lookup metadataLookup
+	if *metadataLookupFile != "" {
+		if lookup, err = loadMetadataLookup(*metadataLookupFile); err != nil {
+			fatal.Fatalf("Error loading metadata lookup: %s", err)
+		}
+	}
+
+	var dependencies dependencyMap
+	if *dependencyFile != "" {
+		if dependencies, err = loadDependencies(*dependencyFile); err != nil {
+			fatal.Fatalf("Error loading dependencies: %s", err)
+		}
+	}
+
+	var allowlist map[string]bool
+	if *allowlistFile != "" {
+		if allowlist, err = loadAllowlist(*allowlistFile); err != nil {
+			fatal.Fatalf("Error loading allowlist: %s", err)
+		}
 	}
 
 	// Create OpenSearch client
-	client, err := opensearch.NewClient(opensearch.Config{
-		Addresses: []string{*opensearchURL},
+	client, err := newClient(connectionOptions{
+		address:            normalizedURL,
+		username:           *username,
+		password:           *password,
+		caCert:             *caCert,
+		insecureSkipVerify: *insecureSkipVerify,
+		verboseHTTP:        *verboseHTTP,
 	})
 	if err != nil {
-		log.Fatalf("Failed to create OpenSearch client: %s", err)
+		fatal.Fatalf("Failed to create OpenSearch client: %s", err)
 	}
 
 	ctx := context.Background()
 
+	// Signal completion once everything else, including the finish hook, is done
+	var summary runSummary
+	defer func() {
+		signalCompletion(*doneFile, *failFile, summary)
+	}()
+
+	// Time-based names and filters are only as good as the local clock
+	if *warnOnClockSkew || *failOnClockSkew {
+		skew, err := measureClockSkew(ctx, client)
+		switch {
+		case err != nil && *failOnClockSkew:
+			fatal.Fatalf("Could not measure clock skew: %s", err)
+		case err != nil:
+			log.Printf("Warning: could not measure clock skew: %s", err)
+		case skew.Abs() > *maxClockSkew && *failOnClockSkew:
+			fatal.Fatalf("Local clock is %s off from the cluster clock, more than --max-clock-skew %s", skew.Round(time.Second), *maxClockSkew)
+		case skew.Abs() > *maxClockSkew:
+			log.Printf("Warning: local clock is %s off from the cluster clock, more than --max-clock-skew %s", skew.Round(time.Second), *maxClockSkew)
+		default:
+			log.Printf("Local clock is %s off from the cluster clock", skew.Round(time.Second))
+		}
+	}
+
+	// Pick master_timeout or cluster_manager_timeout depending on the cluster version
+	if *managerTimeoutFlag > 0 {
+		major, err := resolveCompatVersion(ctx, client, *compatVersion)
+		if err != nil {
+			log.Printf("Could not detect OpenSearch version, falling back to master_timeout: %s", err)
+			major = 1
+		}
+		client.managerTimeout = managerTimeoutParam{Timeout: *managerTimeoutFlag, Legacy: major < 2}
+	}
+
+	arch := &archiver{
+		client: client,
+		repo:   *repoName,
+		lookup: lookup,
+		naming: snapshotNaming{
+			analyze:        *enableAnalyze,
+			separator:      *nameSeparator,
+			missingPolicy:  *analyzeMissingPolicy,
+			lowercase:      *snapshotNameLowercase,
+			timeFormat:     *nameFormat,
+			timestampField: *timestampField,
+		},
+		dryRun:              *dryRun,
+		maxPendingTasks:     *maxPendingTasks,
+		noPartial:           *noPartial,
+		waitForCompletion:   *waitForCompletion,
+		wait:                *wait,
+		deleteAfterSnapshot: *deleteAfterSnapshot,
+		concurrency:         *concurrency,
+		waitTimeout:         *waitTimeout,
+		includeAliases:      *includeAliases,
+		unassignedTimeout:   *unassignedTimeout,
+		deleteHealthStatus:  *deleteHealthStatus,
+		deleteHealthTimeout: *deleteHealthTimeout,
+	}
+	if *repoFromISM {
+		arch.ismRepos = newISMRepoResolver(client)
+	}
+	if *deepRestoreCheck {
+		arch.restoreCheck = &restoreCheck{sampleRate: *verifySampleRate, timeout: *restoreCheckTimeout}
+	}
+	if *simulateFailureRate > 0 {
+		arch.simulator = newFailureSimulator(*simulateFailureRate, *simulateFailureSeed)
+		log.Printf("Warning: simulating failures for %.0f%% of snapshot creations (seed %d), this run is for testing only", *simulateFailureRate*100, *simulateFailureSeed)
+	}
+	if *forceMergeBefore {
+		arch.forceMerge = &forceMerge{maxNumSegments: *maxNumSegments, timeout: *forceMergeTimeout}
+		log.Printf("Indices will be made read-only and force-merged to %d segments before their snapshot", *maxNumSegments)
+	}
+	if *progressFile != "" {
+		arch.progress = newProgressTracker(*progressFile)
+	}
+	if *catalogIndex != "" {
+		arch.catalog = &snapshotCatalog{index: *catalogIndex}
+		for _, tag := range strings.Split(*snapshotTags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				arch.catalog.tags = append(arch.catalog.tags, tag)
+			}
+		}
+	}
+	if *snapshotRateLimit > 0 {
+		arch.rateLimit = newRateLimiter(*snapshotRateLimit)
+		log.Printf("Creating at most one snapshot every %s", *snapshotRateLimit)
+	}
+
+	// Only report on the matching indices
+	if *inventoryFile != "" {
+		if err := writeInventory(ctx, client, *indicesPattern, *enableAnalyze, *timestampField, *inventoryFile, *inventoryFormat); err != nil {
+			fatal.Fatalf("Error writing inventory: %s", err)
+		}
+		return
+	}
+
+	// Only report the timestamp ranges of the matching indices
+	if *analyzeOnly {
+		if err := writeAnalyzeOnly(ctx, client, *indicesPattern, *timestampField, *analyzeOnlyFile, *analyzeOnlyFormat); err != nil {
+			fatal.Fatalf("Error analyzing indices: %s", err)
+		}
+		return
+	}
+
+	// Only report the matching indices that were never archived
+	if *missingSnapshots {
+		if err := writeMissingSnapshots(ctx, client, *indicesPattern, *repoName, *missingSnapshotsFile, *missingSnapshotsFormat); err != nil {
+			fatal.Fatalf("Error reporting missing snapshots: %s", err)
+		}
+		return
+	}
+
+	// Make sure we are about to write to the right repository
+	if *expectRepoLocation != "" {
+		if err := verifyRepositoryLocation(ctx, client, *repoName, *expectRepoLocation); err != nil {
+			fatal.Fatalf("Repository location check failed: %s", err)
+		}
+		log.Printf("Repository %s has the expected location %s", *repoName, *expectRepoLocation)
+	}
+
+	// Turn repository misconfiguration into an immediate error
+	if *failIfNoRepoAccess {
+		if err := checkRepositoryAccess(ctx, client, *repoName, *preflightTestSnapshot); err != nil {
+			fatal.Fatalf("Repository %s is not usable: %s", *repoName, err)
+		}
+		log.Printf("Repository %s is accessible", *repoName)
+	}
+
+	// Remove FAILED snapshots left over from previous runs
+	if *cleanupFailed {
+		if err := cleanupFailedSnapshots(ctx, client, *repoName, *indicesPattern, *confirm, *dryRun); err != nil {
+			fatal.Fatalf("Error cleaning up failed snapshots: %s", err)
+		}
+	}
+
 	// Fetch indices matching the pattern
 	indices, err := getIndices(ctx, client, *indicesPattern)
 	if err != nil {
-		log.Fatalf("Error fetching indices: %s", err)
+		fatal.Fatalf("Error fetching indices: %s", err)
+	}
+	if nameSortKey != nil {
+		nameSortKey.warnUnmatched(indices)
+		sortIndicesByName(indices, nameSortKey)
+	}
+
+	// Make sure the allowlist only names indices that exist
+	if allowlist != nil {
+		if err := checkAllowlistMissing(allowlist, indices); err != nil {
+			if !*allowlistAllowMissing {
+				fatal.Fatalf("Allowlist check failed: %s", err)
+			}
+			log.Printf("Warning: %s", err)
+		}
 	}
 
 	// Filter indices to archive
@@ -54,53 +429,246 @@ func main() {
 	}
 	indicesToArchive := indices[:len(indices)-*numToBypass]
 
-	// Process each index
-	for _, index := range indicesToArchive {
-		snapshotName, err := generateSnapshotName(ctx, client, index, *enableAnalyze)
+	// Keep the index Graylog is currently writing to. It is normally among the
+	// --bypass ones already, detection only matters when it isn't.
+	if *detectActive {
+		kept, err := excludeActiveIndices(ctx, client, *indicesPattern, indicesToArchive)
 		if err != nil {
-			log.Printf("Error generating snapshot name for index %s: %s", index, err)
-			continue
+			log.Printf("Warning: could not detect the active Graylog index, relying on --bypass alone: %s", err)
+		} else {
+			indicesToArchive = kept
 		}
+		if len(indicesToArchive) == 0 {
+			log.Println("No indices to archive.")
+			return
+		}
+	}
 
-		log.Printf("Creating snapshot for index %s: %s", index, snapshotName)
+	// Keep the indices of the most recent days, on top of the --bypass ones
+	if *bypassDays > 0 {
+		retained := retainedByDays(ctx, client, indices, *bypassDays, *bypassDaysUndated, *timestampField)
+		kept := make([]IndexInfo, 0, len(indicesToArchive))
+		for _, index := range indicesToArchive {
+			if retained[index.Name] {
+				log.Printf("Bypassing index %s (--bypass-days %d)", index.Name, *bypassDays)
+				continue
+			}
+			kept = append(kept, index)
+		}
+		indicesToArchive = kept
+		if len(indicesToArchive) == 0 {
+			log.Println("No indices to archive.")
+			return
+		}
+	}
+
+	// Never archive anything outside the allowlist
+	if allowlist != nil {
+		indicesToArchive = filterAllowlist(indicesToArchive, allowlist)
+		if len(indicesToArchive) == 0 {
+			log.Println("No allowlisted indices to archive.")
+			return
+		}
+	}
 
-		if err := createSnapshot(ctx, client, *repoName, index, snapshotName); err != nil {
-			log.Printf("Error creating snapshot for index %s: %s", index, err)
+	// Work out the processing plan before anything runs
+	var consolidated string
+	if *consolidate {
+		sources := indexNames(indicesToArchive)
+		consolidated, err = renderConsolidateTarget(*consolidateTarget, consolidateTemplateData{
+			First:   sources[0],
+			Last:    sources[len(sources)-1],
+			Pattern: *indicesPattern,
+			Now:     time.Now(),
+		})
+		if err != nil {
+			fatal.Fatalf("Invalid --consolidate-target: %s", err)
+		}
+		// The target of this and earlier runs would take --bypass slots and be
+		// consolidated again, into itself or into the next target
+		if matchesIndexPattern(*indicesPattern, consolidated) {
+			fatal.Fatalf("--consolidate-target %s matches --pattern %s, choose a target name outside the pattern.", consolidated, *indicesPattern)
+		}
+	} else {
+		orderIndices(indicesToArchive, *orderBy, nameSortKey)
+		if dependencies != nil {
+			if indicesToArchive, err = orderByDependencies(indicesToArchive, dependencies); err != nil {
+				fatal.Fatalf("Error ordering indices by dependencies: %s", err)
+			}
+		}
+	}
+
+	// Show exactly what would be deleted before asking for confirmation
+	if *deleteAfterSnapshot && !*confirm && !*dryRun {
+		fatal.Printf("--delete-after-snapshot would delete these %d indices once their snapshots are verified:", len(indicesToArchive))
+		for _, index := range indicesToArchive {
+			fatal.Printf("  %s (%s)", index.Name, formatBytes(index.StoreSize))
+		}
+		fatal.Fatalf("--delete-after-snapshot deletes indices and requires --yes.")
+	}
+
+	// The catalog is best effort, archive without it when its index can't be set up
+	if arch.catalog != nil && !*dryRun {
+		if err := arch.catalog.ensureIndex(ctx, client); err != nil {
+			log.Printf("Warning: catalog index %s unavailable, snapshots won't be recorded: %s", *catalogIndex, err)
+			arch.catalog = nil
+		}
+	}
+
+	// Run the start hook, a failure aborts the run before anything is archived
+	if *onStartExec != "" {
+		if err := runHook(ctx, *onStartExec, "start", nil); err != nil {
+			fatal.Fatalf("Start hook failed, aborting: %s", err)
+		}
+	}
+
+	if *consolidate {
+		// Consolidate indices into one archive index and snapshot it instead
+		sources := indexNames(indicesToArchive)
+		summary.Total = len(sources)
+		if err := arch.consolidate(ctx, sources, consolidated, *consolidateDeleteSources); err != nil {
+			log.Printf("Error consolidating indices into %s: %s", consolidated, err)
+			for _, index := range sources {
+				summary.recordFailure(index, "consolidating", err)
+			}
+		} else if *dryRun {
+			summary.Skipped = len(sources)
 		} else {
-			log.Printf("Snapshot created successfully: %s", snapshotName)
+			summary.Succeeded = len(sources)
+		}
+	} else {
+		// Process each index
+		summary = arch.archiveIndices(ctx, indicesToArchive)
+	}
+
+	log.Printf("Archived %d indices: %d succeeded, %d skipped, %d failed", summary.Total, summary.Succeeded, summary.Skipped, summary.Failed)
+	if *groupErrors {
+		logErrorGroups(summary.failures)
+	}
+	if *dryRun {
+		logDryRunProjection(indicesToArchive, summary.existing, *consolidate, *consolidateDeleteSources || *deleteAfterSnapshot)
+	}
+
+	// The run is over, a failing finish hook can only be reported
+	if *onFinishExec != "" {
+		if err := runHook(ctx, *onFinishExec, "finish", &summary); err != nil {
+			log.Printf("Finish hook failed: %s", err)
 		}
 	}
+
+	// Deferred calls don't run on os.Exit, signal completion first
+	if summary.Failed > 0 {
+		signalCompletion(*doneFile, *failFile, summary)
+		os.Exit(1)
+	}
+}
+
+// Index details reported by the cat indices API
+type IndexInfo struct {
+	Name         string
+	Health       string
+	Status       string
+	DocsCount    int64
+	StoreSize    int64 // Bytes
+	CreationDate time.Time
 }
 
 // Fetch indices matching the pattern
-func getIndices(ctx context.Context, client *opensearch.Client, pattern string) ([]string, error) {
+func getIndices(ctx context.Context, client *clusterClient, pattern string) ([]IndexInfo, error) {
+	masterTimeout, clusterManagerTimeout := client.managerTimeout.values()
 	res, err := client.Cat.Indices(
+		client.Cat.Indices.WithContext(ctx),
 		client.Cat.Indices.WithFormat("json"),
 		client.Cat.Indices.WithIndex(pattern),
+		client.Cat.Indices.WithH("index", "health", "status", "docs.count", "store.size", "creation.date"),
+		client.Cat.Indices.WithBytes("b"),
+		client.Cat.Indices.WithMasterTimeout(masterTimeout),
+		client.Cat.Indices.WithClusterManagerTimeout(clusterManagerTimeout),
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	var indices []struct {
-		Index string `json:"index"`
+	if res.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("the cluster rejected the request as unauthorized (401), check --username and --password")
 	}
-	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to list indices: %s", res.String())
+	}
+
+	// Decode loosely, Elasticsearch-compatible endpoints don't always use the
+	// same key layout or value types as OpenSearch
+	var rows []map[string]interface{}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil {
 		return nil, err
 	}
 
-	indexNames := make([]string, len(indices))
+	indices := make([]IndexInfo, len(rows))
+	for i, row := range rows {
+		name := catString(row, "index")
+		if name == "" {
+			return nil, fmt.Errorf("unexpected cat indices response, entry without index name: %v", row)
+		}
+
+		// Closed indices report no docs or size, leave them at zero
+		docsCount, err := catInt(row, "docs.count")
+		if err != nil {
+			return nil, fmt.Errorf("unexpected cat indices response for index %s: %s", name, err)
+		}
+		storeSize, err := catInt(row, "store.size")
+		if err != nil {
+			return nil, fmt.Errorf("unexpected cat indices response for index %s: %s", name, err)
+		}
+		creationDate, err := catInt(row, "creation.date")
+		if err != nil {
+			return nil, fmt.Errorf("unexpected cat indices response for index %s: %s", name, err)
+		}
+		indices[i] = IndexInfo{
+			Name:         name,
+			Health:       catString(row, "health"),
+			Status:       catString(row, "status"),
+			DocsCount:    docsCount,
+			StoreSize:    storeSize,
+			CreationDate: time.UnixMilli(creationDate),
+		}
+	}
+
+	// Sort indices by their numeric suffix, see --sort-key-regex for other orders
+	sortIndicesByName(indices, nil)
+
+	return indices, nil
+}
+
+// Names of the given indices, in the same order
+func indexNames(indices []IndexInfo) []string {
+	names := make([]string, len(indices))
 	for i, index := range indices {
-		indexNames[i] = index.Index
+		names[i] = index.Name
 	}
+	return names
+}
 
-	// Extract numeric suffix and sort indices
-	sort.Slice(indexNames, func(i, j int) bool {
-		return extractIndexNumber(indexNames[i]) < extractIndexNumber(indexNames[j])
-	})
+// Supported values for --order-by
+var orderByValues = []string{"number", "date", "size", "docs"}
 
-	return indexNames, nil
+// Sort indices into processing order: oldest first for number and date,
+// largest first for size and docs
+func orderIndices(indices []IndexInfo, orderBy string, key *sortKey) {
+	sort.SliceStable(indices, func(i, j int) bool {
+		switch orderBy {
+		case "date":
+			return indices[i].CreationDate.Before(indices[j].CreationDate)
+		case "size":
+			return indices[i].StoreSize > indices[j].StoreSize
+		case "docs":
+			return indices[i].DocsCount > indices[j].DocsCount
+		default:
+			return indexNameLess(key, indices[i].Name, indices[j].Name)
+		}
+	})
 }
 
 // Extract numeric suffix from index name
@@ -114,102 +682,434 @@ func extractIndexNumber(index string) int {
 	return num
 }
 
-// Generate snapshot name
-func generateSnapshotName(ctx context.Context, client *opensearch.Client, index string, analyze bool) (string, error) {
-	if analyze {
-		minTS, maxTS, err := analyzeTimestamps(ctx, client, index)
+// How snapshot names are built from index names
+type snapshotNaming struct {
+	analyze        bool   // Append the min/max timestamps of the index data
+	separator      string // Joins the index name and timestamps
+	missingPolicy  string // What to do with indices without timestamps, one of missingTimestampPolicies
+	lowercase      bool   // Lowercase the generated name before validating it
+	timeFormat     string // Go layout of the min/max timestamps
+	timestampField string // Field the min/max timestamps are read from
+}
+
+// Supported values for --analyze-missing-policy
+var missingTimestampPolicies = []string{"skip", "plain-name", "error", "epoch"}
+
+// Returned instead of a snapshot name when the index should not be snapshotted
+var errSkipIndex = errors.New("index skipped")
+
+// Generate snapshot name, along with the timestamp range it was built from
+// when the index was analyzed and has timestamp values
+func generateSnapshotName(ctx context.Context, l *indexLogger, client *clusterClient, index string, naming snapshotNaming) (string, *timestampRange, error) {
+	name, timestamps, err := buildSnapshotName(ctx, l, client, index, naming)
+	if err != nil {
+		return "", nil, err
+	}
+	if naming.lowercase {
+		name = strings.ToLower(name)
+	}
+	if err := validateSnapshotName(name); err != nil {
+		return "", nil, err
+	}
+	return name, timestamps, nil
+}
+
+func buildSnapshotName(ctx context.Context, l *indexLogger, client *clusterClient, index string, naming snapshotNaming) (string, *timestampRange, error) {
+	if naming.analyze {
+		timestamps, err := analyzeTimestamps(ctx, client, index, naming.timestampField)
 		if err != nil {
-			return "", err
+			return "", nil, err
+		}
+		name := strings.Join([]string{index, timestamps.Min.Format(naming.timeFormat), timestamps.Max.Format(naming.timeFormat)}, naming.separator)
+		if !timestamps.Missing {
+			return name, &timestamps, nil
+		}
+		switch naming.missingPolicy {
+		case "skip":
+			l.Printf("Index %s has no timestamp values, skipping it", index)
+			return "", nil, errSkipIndex
+		case "error":
+			return "", nil, fmt.Errorf("index %s has no timestamp values", index)
+		case "epoch":
+			// Min and Max are the Unix epoch, keep naming such indices like older releases did
+			return name, nil, nil
+		default:
+			l.Printf("Warning: index %s has no timestamp values, using the plain index name", index)
+			return index, nil, nil
 		}
-		return fmt.Sprintf("%s.%s.%s", index, minTS, maxTS), nil
 	}
 
-	return fmt.Sprintf("%s", index), nil
+	return fmt.Sprintf("%s", index), nil, nil
 }
 
-// Analyze min/max timestamps of data in the index
-func analyzeTimestamps(ctx context.Context, client *opensearch.Client, index string) (string, string, error) {
-	query := `{
-		"size": 0,
-		"aggs": {
-			"min_time": { "min": { "field": "timestamp" } },
-			"max_time": { "max": { "field": "timestamp" } }
-		}
-	}`
-
-	res, err := client.Search(
-		client.Search.WithContext(ctx),
-		client.Search.WithIndex(index),
-		client.Search.WithBody(strings.NewReader(query)),
-		client.Search.WithPretty(),
-	)
-	if err != nil {
-		return "", "", err
+// Check a snapshot name against the rules OpenSearch applies on create, so
+// that invalid names fail before any request is sent
+func validateSnapshotName(name string) error {
+	if name == "" {
+		return fmt.Errorf("snapshot name must not be empty")
 	}
-	defer res.Body.Close()
+	if strings.HasPrefix(name, "_") {
+		return fmt.Errorf("snapshot name %q must not start with _", name)
+	}
+	if name != strings.ToLower(name) {
+		return fmt.Errorf("snapshot name %q must be lowercase, OpenSearch rejects uppercase snapshot names", name)
+	}
+	if strings.ContainsAny(name, invalidSnapshotNameChars) {
+		return fmt.Errorf("snapshot name %q contains characters not allowed in snapshot names (%s)", name, invalidSnapshotNameChars)
+	}
+	return nil
+}
 
-	var result struct {
-		Aggregations struct {
-			MinTime struct {
-				Value float64 `json:"value"`
-			} `json:"min_time"`
-			MaxTime struct {
-				Value float64 `json:"value"`
-			} `json:"max_time"`
-		} `json:"aggregations"`
+// Validate that the separator only uses characters allowed in snapshot names
+func validateNameSeparator(separator string) error {
+	if separator == "" {
+		return fmt.Errorf("separator must not be empty")
+	}
+	if separator != strings.ToLower(separator) {
+		return fmt.Errorf("separator %q must be lowercase", separator)
+	}
+	if strings.ContainsAny(separator, invalidSnapshotNameChars) {
+		return fmt.Errorf("separator %q contains characters not allowed in snapshot names (%s)", separator, invalidSnapshotNameChars)
 	}
+	return nil
+}
 
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return "", "", err
+// Validate that the layout renders to characters allowed in snapshot names and
+// actually includes part of the time
+func validateNameFormat(layout string) error {
+	sample := time.Date(2024, time.December, 31, 23, 59, 58, 0, time.UTC).Format(layout)
+	if sample == layout {
+		return fmt.Errorf("layout %q contains no time elements, see https://pkg.go.dev/time#pkg-constants", layout)
 	}
+	if strings.ContainsAny(sample, invalidSnapshotNameChars) {
+		return fmt.Errorf("layout %q renders as %q, which contains characters not allowed in snapshot names (%s)", layout, sample, invalidSnapshotNameChars)
+	}
+	return nil
+}
+
+// Snapshot metadata key recording whether restores should bring back the index
+// aliases. The create API has no such option, aliases are always stored and
+// only left out by a restore that asks for it.
+const includeAliasesMetadataKey = "include_aliases"
 
-	minTime := time.Unix(int64(result.Aggregations.MinTime.Value/1000), 0).Format("20060102-1504")
-	maxTime := time.Unix(int64(result.Aggregations.MaxTime.Value/1000), 0).Format("20060102-1504")
-	return minTime, maxTime, nil
+// Optional settings of a snapshot create request
+type snapshotOptions struct {
+	Metadata  map[string]interface{} // Stored with the snapshot
+	NoPartial bool                   // Explicitly send partial=false so a missing shard fails the whole snapshot
+	Wait      bool                   // Block until the cluster finishes the snapshot, logging progress meanwhile
+	NoAliases bool                   // Record include_aliases=false in the metadata, restores then leave aliases out
 }
 
+// Returned by createSnapshot when a usable snapshot of that name already exists
+var errSnapshotExists = errors.New("snapshot already exists")
+
 // Create snapshot for the index
-func createSnapshot(ctx context.Context, client *opensearch.Client, repo, index, snapshot string) error {
-	// Check if the snapshot already exists
-	if snapshotExists(ctx, client, repo, snapshot) {
-		log.Printf("Snapshot %s already exists. Skipping creation.", snapshot)
-		return nil
+func createSnapshot(ctx context.Context, l *indexLogger, client *clusterClient, repo, index, snapshot string, opts snapshotOptions) error {
+	if err := checkExistingSnapshot(ctx, l, client, repo, snapshot); err != nil {
+		return err
 	}
 
-	body := fmt.Sprintf(`{
-		"indices": "%s",
-		"include_global_state": false
-	}`, index)
+	metadata := opts.Metadata
+	if opts.NoAliases {
+		metadata = make(map[string]interface{}, len(opts.Metadata)+1)
+		maps.Copy(metadata, opts.Metadata)
+		metadata[includeAliasesMetadataKey] = false
+	}
+
+	request := map[string]interface{}{
+		"indices":              index,
+		"include_global_state": false,
+	}
+	if len(metadata) > 0 {
+		request["metadata"] = metadata
+	}
+	if opts.NoPartial {
+		request["partial"] = false
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
 
 	req := opensearchapi.SnapshotCreateRequest{
 		Repository: repo,
 		Snapshot:   snapshot,
-		Body:       strings.NewReader(body),
+		Body:       bytes.NewReader(body),
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	if opts.Wait {
+		req.WaitForCompletion = &opts.Wait
+		stop := logSnapshotProgress(ctx, l, client, repo, snapshot)
+		defer stop()
 	}
 	res, err := req.Do(ctx, client)
 	if err != nil {
+		if snapshotCreatedByEarlierAttempt(ctx, l, client, repo, snapshot) {
+			return nil
+		}
 		return err
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
+		if snapshotCreatedByEarlierAttempt(ctx, l, client, repo, snapshot) {
+			return nil
+		}
 		return fmt.Errorf("failed to create snapshot: %s", res.String())
 	}
+
+	if opts.Wait {
+		var result struct {
+			Snapshot snapshotInfo `json:"snapshot"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			return err
+		}
+		return checkCompletedSnapshot(result.Snapshot)
+	}
 	return nil
 }
 
-func snapshotExists(ctx context.Context, client *opensearch.Client, repo, snapshot string) bool {
+// Turn the final state of a snapshot into an error unless every shard succeeded
+func checkCompletedSnapshot(info snapshotInfo) error {
+	switch info.State {
+	case "SUCCESS":
+		return nil
+	case "PARTIAL":
+		return fmt.Errorf("snapshot %s is PARTIAL, %d/%d shards failed", info.Snapshot, info.Shards.Failed, info.Shards.Total)
+	default:
+		return fmt.Errorf("snapshot %s ended in state %s", info.Snapshot, info.State)
+	}
+}
+
+// The client transparently retries requests that time out at a proxy (502/503/504),
+// so a failed create may only mean that a retry collided with an attempt that
+// actually went through. Re-check the repository before reporting a failure.
+func snapshotCreatedByEarlierAttempt(ctx context.Context, l *indexLogger, client *clusterClient, repo, snapshot string) bool {
+	if !snapshotExists(ctx, l, client, repo, snapshot) {
+		return false
+	}
+	l.Printf("Snapshot %s was created by an earlier attempt of the same request.", snapshot)
+	return true
+}
+
+// Whether the repository holds the snapshot in a usable state, finished
+// successfully or still running. A FAILED or PARTIAL snapshot of the same name
+// doesn't count, the index still lacks a complete snapshot.
+func snapshotExists(ctx context.Context, l *indexLogger, client *clusterClient, repo, snapshot string) bool {
+	switch state := snapshotState(ctx, l, client, repo, snapshot); state {
+	case "":
+		return false
+	case "SUCCESS", "IN_PROGRESS", "STARTED":
+		return true
+	default:
+		l.Printf("Snapshot %s exists in state %s, not treating it as created", snapshot, state)
+		return false
+	}
+}
+
+// Check the name before creating a snapshot. errSnapshotExists means there is
+// nothing to do. A snapshot that didn't complete blocks the name until it is
+// deleted, OpenSearch would reject the create with a less helpful error.
+func checkExistingSnapshot(ctx context.Context, l *indexLogger, client *clusterClient, repo, snapshot string) error {
+	switch state := snapshotState(ctx, l, client, repo, snapshot); state {
+	case "":
+		return nil
+	case "SUCCESS", "IN_PROGRESS", "STARTED":
+		return errSnapshotExists
+	case "FAILED":
+		return fmt.Errorf("snapshot %s already exists in state FAILED, delete it with --cleanup-failed --yes to snapshot the index again", snapshot)
+	default:
+		return fmt.Errorf("snapshot %s already exists in state %s, delete it (DELETE _snapshot/%s/%s) to snapshot the index again", snapshot, state, repo, snapshot)
+	}
+}
+
+// State of the snapshot, empty when it doesn't exist or can't be looked up
+func snapshotState(ctx context.Context, l *indexLogger, client *clusterClient, repo, snapshot string) string {
 	req := opensearchapi.SnapshotGetRequest{
 		Repository: repo,
 		Snapshot:   []string{snapshot},
 	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
 	res, err := req.Do(ctx, client)
 	if err != nil {
-		log.Printf("Error checking for snapshot %s: %s", snapshot, err)
-		return false
+		l.Printf("Error checking for snapshot %s: %s", snapshot, err)
+		return ""
 	}
 	defer res.Body.Close()
 
-	// If the snapshot exists, the response won't be an error
-	return !res.IsError()
+	// A missing snapshot is reported as an error
+	if res.IsError() {
+		return ""
+	}
+
+	var result struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		l.Printf("Error checking for snapshot %s: %s", snapshot, err)
+		return ""
+	}
+	if len(result.Snapshots) == 0 {
+		return ""
+	}
+	return result.Snapshots[0].State
+}
+
+// State and shard counts of a snapshot as reported by the snapshot get API
+type snapshotInfo struct {
+	Snapshot string                 `json:"snapshot"`
+	State    string                 `json:"state"`
+	Indices  []string               `json:"indices"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Shards   struct {
+		Total      int `json:"total"`
+		Failed     int `json:"failed"`
+		Successful int `json:"successful"`
+	} `json:"shards"`
+}
+
+func getSnapshotInfo(ctx context.Context, client *clusterClient, repo, snapshot string) (snapshotInfo, error) {
+	req := opensearchapi.SnapshotGetRequest{
+		Repository: repo,
+		Snapshot:   []string{snapshot},
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return snapshotInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return snapshotInfo{}, fmt.Errorf("failed to get snapshot: %s", res.String())
+	}
+
+	var result struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return snapshotInfo{}, err
+	}
+	if len(result.Snapshots) == 0 {
+		return snapshotInfo{}, fmt.Errorf("snapshot %s not found", snapshot)
+	}
+	return result.Snapshots[0], nil
+}
+
+// How often to poll a running snapshot for completion
+const snapshotPollInterval = 10 * time.Second
+
+// First poll delay while waiting for a snapshot, doubled up to snapshotPollInterval
+const snapshotInitialPollInterval = time.Second
+
+// Poll the snapshot until it leaves the IN_PROGRESS state or the timeout expires
+func waitForSnapshot(ctx context.Context, client *clusterClient, repo, snapshot string, timeout time.Duration) (snapshotInfo, error) {
+	deadline := time.Now().Add(timeout)
+	backoff := snapshotInitialPollInterval
+	for {
+		info, err := getSnapshotInfo(ctx, client, repo, snapshot)
+		if err != nil {
+			return info, err
+		}
+		if info.State != "IN_PROGRESS" && info.State != "STARTED" {
+			return info, nil
+		}
+		if time.Now().After(deadline) {
+			return info, fmt.Errorf("snapshot %s still %s after %s", snapshot, info.State, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return info, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, snapshotPollInterval)
+	}
+}
+
+// List snapshots matching the pattern that ended in FAILED state
+func getFailedSnapshots(ctx context.Context, client *clusterClient, repo, pattern string) ([]string, error) {
+	req := opensearchapi.SnapshotGetRequest{
+		Repository: repo,
+		Snapshot:   []string{pattern},
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to list snapshots: %s", res.String())
+	}
+
+	var result struct {
+		Snapshots []struct {
+			Snapshot string `json:"snapshot"`
+			State    string `json:"state"`
+		} `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var failed []string
+	for _, snapshot := range result.Snapshots {
+		if snapshot.State == "FAILED" {
+			failed = append(failed, snapshot.Snapshot)
+		}
+	}
+	return failed, nil
+}
+
+// Delete FAILED snapshots matching the pattern
+func cleanupFailedSnapshots(ctx context.Context, client *clusterClient, repo, pattern string, confirm, dryRun bool) error {
+	failed, err := getFailedSnapshots(ctx, client, repo, pattern)
+	if err != nil {
+		return err
+	}
+	if len(failed) == 0 {
+		log.Println("No failed snapshots to clean up.")
+		return nil
+	}
+
+	if dryRun || !confirm {
+		for _, snapshot := range failed {
+			log.Printf("Failed snapshot to delete: %s", snapshot)
+		}
+		if dryRun {
+			log.Printf("[dry-run] Would delete %d failed snapshots.", len(failed))
+			return nil
+		}
+		return fmt.Errorf("refusing to delete %d failed snapshots without --yes", len(failed))
+	}
+
+	for _, snapshot := range failed {
+		if err := deleteSnapshot(ctx, client, repo, snapshot); err != nil {
+			log.Printf("Error deleting failed snapshot %s: %s", snapshot, err)
+			continue
+		}
+		log.Printf("Deleted failed snapshot: %s", snapshot)
+	}
+	return nil
+}
+
+func deleteSnapshot(ctx context.Context, client *clusterClient, repo, snapshot string) error {
+	req := opensearchapi.SnapshotDeleteRequest{
+		Repository: repo,
+		Snapshot:   []string{snapshot},
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to delete snapshot: %s", res.String())
+	}
+	return nil
 }
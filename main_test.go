@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Client talking to a test server that answers every request with the handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *clusterClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := newClient(connectionOptions{address: server.URL})
+	if err != nil {
+		t.Fatalf("newClient: %s", err)
+	}
+	return client
+}
+
+func decodeCatRow(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var row map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&row); err != nil {
+		t.Fatalf("decoding %s: %s", raw, err)
+	}
+	return row
+}
+
+func TestCatInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     string
+		want    int64
+		wantErr bool
+	}{
+		{name: "string", row: `{"docs.count": "42"}`, want: 42},
+		{name: "number", row: `{"docs.count": 42}`, want: 42},
+		{name: "float", row: `{"docs.count": 42.0}`, want: 42},
+		{name: "nested", row: `{"docs": {"count": 42}}`, want: 42},
+		{name: "nested string", row: `{"docs": {"count": "42"}}`, want: 42},
+		{name: "null", row: `{"docs.count": null}`, want: 0},
+		{name: "missing", row: `{}`, want: 0},
+		{name: "malformed", row: `{"docs.count": "forty-two"}`, wantErr: true},
+		{name: "malformed nested", row: `{"docs": {"count": true}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := catInt(decodeCatRow(t, tt.row), "docs.count")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("catInt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("catInt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIndices(t *testing.T) {
+	created := time.UnixMilli(1700000000000)
+	tests := []struct {
+		name    string
+		body    string
+		want    []IndexInfo
+		wantErr string
+	}{
+		{
+			name: "string values",
+			body: `[
+				{"index": "uat_2", "health": "green", "status": "open", "docs.count": "20", "store.size": "2048", "creation.date": "1700000000000"},
+				{"index": "uat_1", "health": "yellow", "status": "open", "docs.count": "10", "store.size": "1024", "creation.date": "1700000000000"}
+			]`,
+			want: []IndexInfo{
+				{Name: "uat_1", Health: "yellow", Status: "open", DocsCount: 10, StoreSize: 1024, CreationDate: created},
+				{Name: "uat_2", Health: "green", Status: "open", DocsCount: 20, StoreSize: 2048, CreationDate: created},
+			},
+		},
+		{
+			name: "numeric values",
+			body: `[
+				{"index": "uat_1", "health": "green", "status": "open", "docs.count": 10, "store.size": 1024, "creation.date": 1700000000000}
+			]`,
+			want: []IndexInfo{
+				{Name: "uat_1", Health: "green", Status: "open", DocsCount: 10, StoreSize: 1024, CreationDate: created},
+			},
+		},
+		{
+			name: "nested keys",
+			body: `[
+				{"index": "uat_1", "health": "green", "status": "open", "docs": {"count": 10}, "store": {"size": "1024"}, "creation": {"date": 1700000000000}}
+			]`,
+			want: []IndexInfo{
+				{Name: "uat_1", Health: "green", Status: "open", DocsCount: 10, StoreSize: 1024, CreationDate: created},
+			},
+		},
+		{
+			name: "closed index",
+			body: `[
+				{"index": "uat_1", "status": "close", "docs.count": null, "store.size": null, "creation.date": "1700000000000"}
+			]`,
+			want: []IndexInfo{
+				{Name: "uat_1", Status: "close", CreationDate: created},
+			},
+		},
+		{
+			name:    "malformed value",
+			body:    `[{"index": "uat_1", "docs.count": "10", "store.size": "1kb", "creation.date": "1700000000000"}]`,
+			wantErr: `index uat_1: store.size is "1kb", expected a number`,
+		},
+		{
+			name:    "missing index name",
+			body:    `[{"health": "green", "docs.count": "10"}]`,
+			wantErr: "entry without index name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.URL.Path, "/_cat/indices/uat_*") {
+					t.Errorf("unexpected request %s", r.URL.Path)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			})
+
+			got, err := getIndices(context.Background(), client, "uat_*")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("getIndices() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getIndices() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getIndices() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIndicesUnauthorized(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := getIndices(context.Background(), client, "uat_*")
+	if err == nil || !strings.Contains(err.Error(), "--username and --password") {
+		t.Fatalf("getIndices() error = %v, want a hint about the credentials", err)
+	}
+}
+
+func TestGenerateSnapshotName(t *testing.T) {
+	tests := []struct {
+		name      string
+		index     string
+		lowercase bool
+		want      string
+		wantErr   string
+	}{
+		{name: "lowercase index", index: "uat_1", lowercase: true, want: "uat_1"},
+		{name: "uppercase index lowercased", index: "UAT_Logs_1", lowercase: true, want: "uat_logs_1"},
+		{name: "uppercase index kept", index: "UAT_Logs_1", lowercase: false, wantErr: "must be lowercase"},
+		{name: "lowercase index kept", index: "uat_1", lowercase: false, want: "uat_1"},
+		{name: "invalid character", index: "uat#1", lowercase: true, wantErr: "characters not allowed"},
+		{name: "leading underscore", index: "_uat_1", lowercase: true, wantErr: "must not start with _"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			naming := snapshotNaming{separator: ".", missingPolicy: "plain-name", lowercase: tt.lowercase, timeFormat: snapshotTimeFormat}
+			got, _, err := generateSnapshotName(context.Background(), nil, nil, tt.index, naming)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("generateSnapshotName() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("generateSnapshotName() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("generateSnapshotName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSnapshotName(t *testing.T) {
+	valid := []string{"uat_1", "uat_1.20240101-0000.20240131-2359", "graylog-archive-1"}
+	for _, name := range valid {
+		if err := validateSnapshotName(name); err != nil {
+			t.Errorf("validateSnapshotName(%q) = %v, want nil", name, err)
+		}
+	}
+
+	invalid := []string{"", "_uat", "Uat_1", "uat 1", "uat,1", "uat#1", "uat*", "uat?", `uat"1`, "uat<1", "uat>1", "uat|1", `uat\1`, "uat/1"}
+	for _, name := range invalid {
+		if err := validateSnapshotName(name); err == nil {
+			t.Errorf("validateSnapshotName(%q) = nil, want an error", name)
+		}
+	}
+}
+
+func TestCheckExistingSnapshot(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "missing", status: http.StatusNotFound, body: `{"error": {"type": "snapshot_missing_exception"}, "status": 404}`},
+		{name: "success", status: http.StatusOK, body: `{"snapshots": [{"snapshot": "uat_1", "state": "SUCCESS"}]}`, wantErr: errSnapshotExists.Error()},
+		{name: "in progress", status: http.StatusOK, body: `{"snapshots": [{"snapshot": "uat_1", "state": "IN_PROGRESS"}]}`, wantErr: errSnapshotExists.Error()},
+		{name: "failed", status: http.StatusOK, body: `{"snapshots": [{"snapshot": "uat_1", "state": "FAILED"}]}`, wantErr: "state FAILED, delete it with --cleanup-failed --yes"},
+		{name: "partial", status: http.StatusOK, body: `{"snapshots": [{"snapshot": "uat_1", "state": "PARTIAL"}]}`, wantErr: "state PARTIAL, delete it (DELETE _snapshot/backup/uat_1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			err := checkExistingSnapshot(context.Background(), newIndexLogger(1, 1, "uat_1"), client, "backup", "uat_1")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkExistingSnapshot() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkExistingSnapshot() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
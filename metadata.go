@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Snapshot metadata keyed by exact index name or index name prefix
+type metadataLookup map[string]map[string]interface{}
+
+// Load the metadata lookup from a JSON file
+func loadMetadataLookup(path string) (metadataLookup, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lookup metadataLookup
+	if err := json.Unmarshal(data, &lookup); err != nil {
+		return nil, fmt.Errorf("invalid metadata lookup file %s: %s", path, err)
+	}
+	return lookup, nil
+}
+
+// Find the metadata for the index, preferring an exact name match over the
+// longest matching prefix. Returns nil when the index has no entry.
+func (l metadataLookup) find(index string) map[string]interface{} {
+	if metadata, ok := l[index]; ok {
+		return metadata
+	}
+
+	var (
+		best       map[string]interface{}
+		bestLength int
+	)
+	for prefix, metadata := range l {
+		if strings.HasPrefix(index, prefix) && len(prefix) > bestLength {
+			best, bestLength = metadata, len(prefix)
+		}
+	}
+	return best
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// One index without any snapshot in the missing-snapshots report
+type missingSnapshotRecord struct {
+	Index        string    `json:"index"`
+	Health       string    `json:"health"`
+	Status       string    `json:"status"`
+	DocsCount    int64     `json:"docs_count"`
+	StoreSize    int64     `json:"store_size_bytes"`
+	CreationDate time.Time `json:"creation_date"`
+}
+
+func (missingSnapshotRecord) csvHeader() []string {
+	return []string{"index", "health", "status", "docs_count", "store_size_bytes", "creation_date"}
+}
+
+func (r missingSnapshotRecord) csvRow() []string {
+	return []string{
+		r.Index,
+		r.Health,
+		r.Status,
+		strconv.FormatInt(r.DocsCount, 10),
+		strconv.FormatInt(r.StoreSize, 10),
+		r.CreationDate.Format(time.RFC3339),
+	}
+}
+
+// Indices contained in at least one snapshot of the repository that didn't fail
+func snapshottedIndices(ctx context.Context, client *clusterClient, repo string) (map[string]bool, error) {
+	req := opensearchapi.SnapshotGetRequest{
+		Repository: repo,
+		Snapshot:   []string{"_all"},
+		FilterPath: []string{"snapshots.state", "snapshots.indices"},
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to list snapshots: %s", res.String())
+	}
+
+	var result struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	covered := make(map[string]bool)
+	for _, snapshot := range result.Snapshots {
+		if snapshot.State == "FAILED" {
+			continue
+		}
+		for _, index := range snapshot.Indices {
+			covered[index] = true
+		}
+	}
+	return covered, nil
+}
+
+// Report the indices matching the pattern that no snapshot in the repository contains
+func writeMissingSnapshots(ctx context.Context, client *clusterClient, pattern, repo, path, format string) error {
+	indices, err := getIndices(ctx, client, pattern)
+	if err != nil {
+		return err
+	}
+	covered, err := snapshottedIndices(ctx, client, repo)
+	if err != nil {
+		return err
+	}
+
+	var records []missingSnapshotRecord
+	for _, index := range indices {
+		if covered[index.Name] {
+			continue
+		}
+		records = append(records, missingSnapshotRecord{
+			Index:        index.Name,
+			Health:       index.Health,
+			Status:       index.Status,
+			DocsCount:    index.DocsCount,
+			StoreSize:    index.StoreSize,
+			CreationDate: index.CreationDate,
+		})
+	}
+
+	if err := writeReport(path, format, records); err != nil {
+		return err
+	}
+	log.Printf("%d of %d indices have no snapshot in repository %s", len(records), len(indices), repo)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Shard and byte progress of a running snapshot as reported by the snapshot status API
+type snapshotProgress struct {
+	State       string `json:"state"`
+	ShardsStats struct {
+		Done  int `json:"done"`
+		Total int `json:"total"`
+	} `json:"shards_stats"`
+	Stats struct {
+		Incremental struct {
+			SizeInBytes int64 `json:"size_in_bytes"`
+		} `json:"incremental"`
+		Processed struct {
+			SizeInBytes int64 `json:"size_in_bytes"`
+		} `json:"processed"`
+	} `json:"stats"`
+}
+
+func getSnapshotProgress(ctx context.Context, client *clusterClient, repo, snapshot string) (snapshotProgress, error) {
+	req := opensearchapi.SnapshotStatusRequest{
+		Repository: repo,
+		Snapshot:   []string{snapshot},
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return snapshotProgress{}, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return snapshotProgress{}, fmt.Errorf("failed to get snapshot status: %s", res.String())
+	}
+
+	var result struct {
+		Snapshots []snapshotProgress `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return snapshotProgress{}, err
+	}
+	if len(result.Snapshots) == 0 {
+		return snapshotProgress{}, fmt.Errorf("snapshot %s not found", snapshot)
+	}
+	return result.Snapshots[0], nil
+}
+
+// Log the progress of the snapshot until the context is cancelled. Run it in the
+// background while a create with wait_for_completion blocks, the returned
+// function stops the poller and waits for it to exit.
+func logSnapshotProgress(ctx context.Context, l *indexLogger, client *clusterClient, repo, snapshot string) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(snapshotPollInterval):
+			}
+
+			progress, err := getSnapshotProgress(ctx, client, repo, snapshot)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				l.Printf("Could not get progress of snapshot %s: %s", snapshot, err)
+				continue
+			}
+			l.Printf("Snapshot %s %s: %d/%d shards done, %s/%s transferred", snapshot, progress.State,
+				progress.ShardsStats.Done, progress.ShardsStats.Total,
+				formatBytes(progress.Stats.Processed.SizeInBytes), formatBytes(progress.Stats.Incremental.SizeInBytes))
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Content of the --progress-file
+type progressState struct {
+	Total     int       `json:"total"`
+	Done      int       `json:"done"`
+	Succeeded int       `json:"succeeded"`
+	Skipped   int       `json:"skipped"`
+	Failed    int       `json:"failed"`
+	InFlight  int       `json:"in_flight"`
+	Current   []string  `json:"current"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Rewrites the --progress-file whenever an index starts or finishes. A nil
+// tracker does nothing.
+type progressTracker struct {
+	path  string
+	mu    sync.Mutex
+	state progressState
+}
+
+func newProgressTracker(path string) *progressTracker {
+	return &progressTracker{path: path, state: progressState{Current: []string{}}}
+}
+
+// Record that the index is being processed
+func (p *progressTracker) begin(index string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state.Current = append(p.state.Current, index)
+	p.state.InFlight = len(p.state.Current)
+	p.write()
+}
+
+// Record that the index is done and take the counts from the summary
+func (p *progressTracker) end(index string, summary runSummary) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if i := slices.Index(p.state.Current, index); i >= 0 {
+		p.state.Current = slices.Delete(p.state.Current, i, i+1)
+	}
+	p.state.InFlight = len(p.state.Current)
+	p.setCounts(summary)
+	p.write()
+}
+
+// Record the counts of the summary without changing the indices in flight
+func (p *progressTracker) update(summary runSummary) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setCounts(summary)
+	p.write()
+}
+
+func (p *progressTracker) setCounts(summary runSummary) {
+	p.state.Total = summary.Total
+	p.state.Succeeded = summary.Succeeded
+	p.state.Skipped = summary.Skipped
+	p.state.Failed = summary.Failed
+	p.state.Done = summary.Succeeded + summary.Skipped + summary.Failed
+}
+
+func (p *progressTracker) write() {
+	p.state.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(p.state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(p.path, append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("Warning: could not write progress file %s: %s", p.path, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Supported values for report output formats
+var reportFormats = []string{"json", "csv"}
+
+// A record that can be written as a CSV row
+type csvRecord interface {
+	csvHeader() []string
+	csvRow() []string
+}
+
+// Write records to the file as a JSON array or as CSV with a header row.
+// An empty path writes to stdout.
+func writeReport[T csvRecord](path, format string, records []T) error {
+	f := os.Stdout
+	if path != "" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []T{}
+		}
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+	case "csv":
+		var zero T
+		w := csv.NewWriter(f)
+		if err := w.Write(zero.csvHeader()); err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := w.Write(record.csvRow()); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+
+	if path == "" {
+		return nil
+	}
+	return f.Close()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Where the repository stores its data, built from the settings that identify
+// the storage of each repository type, e.g. "my-bucket/cluster-a" for s3
+func repositoryLocation(repoType string, settings map[string]interface{}) (string, error) {
+	setting := func(key string) string {
+		if value, ok := settings[key]; ok {
+			return strings.Trim(fmt.Sprint(value), "/")
+		}
+		return ""
+	}
+
+	var location string
+	switch repoType {
+	case "fs":
+		return setting("location"), nil
+	case "url":
+		return setting("url"), nil
+	case "s3", "gcs":
+		location = setting("bucket")
+	case "azure":
+		location = setting("container")
+	default:
+		return "", fmt.Errorf("repositories of type %s are not supported", repoType)
+	}
+	if basePath := setting("base_path"); basePath != "" {
+		location += "/" + basePath
+	}
+	return location, nil
+}
+
+// Fetch the storage location of the registered repository
+func getRepositoryLocation(ctx context.Context, client *clusterClient, repo string) (string, error) {
+	req := opensearchapi.SnapshotGetRepositoryRequest{Repository: []string{repo}}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", diagnoseRepositoryError(res)
+	}
+
+	var repositories map[string]struct {
+		Type     string                 `json:"type"`
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&repositories); err != nil {
+		return "", err
+	}
+	repository, ok := repositories[repo]
+	if !ok {
+		return "", fmt.Errorf("repository %s is not registered", repo)
+	}
+	return repositoryLocation(repository.Type, repository.Settings)
+}
+
+// Refuse to continue unless the repository stores its data at the expected location
+func verifyRepositoryLocation(ctx context.Context, client *clusterClient, repo, expected string) error {
+	location, err := getRepositoryLocation(ctx, client, repo)
+	if err != nil {
+		return err
+	}
+	if location != strings.Trim(expected, "/") {
+		return fmt.Errorf("repository %s stores its data at %s, expected %s", repo, location, expected)
+	}
+	return nil
+}
+
+// Known repository failures and what they usually mean. Types are matched
+// exactly against the exception types, reasons are fragments of the exception
+// reasons, which carry the error codes of the storage provider.
+var repositoryDiagnostics = []struct {
+	types   []string
+	reasons []string
+	hint    string
+}{
+	{[]string{"repository_missing_exception"}, nil, "the repository is not registered on this cluster"},
+	{nil, []string{"Error Code: AccessDenied", "Status Code: 403", "AuthorizationFailed", "AuthorizationPermissionMismatch"}, "the cluster nodes lack permission to write to the repository storage (check IAM policy or credentials)"},
+	{nil, []string{"Error Code: NoSuchBucket", "ContainerNotFound", "bucket does not exist"}, "the storage bucket or container does not exist"},
+	{nil, []string{"Error Code: PermanentRedirect", "Error Code: AuthorizationHeaderMalformed"}, "the bucket lives in a different region than the repository is configured for"},
+	{[]string{"access_denied_exception", "read_only_file_system_exception"}, []string{"not accessible on master node", "not accessible on cluster-manager node"}, "the repository path is not writable from every node (check path.repo and file system permissions)"},
+}
+
+// Error object of an OpenSearch error response, with its causes
+type opensearchError struct {
+	Type      string            `json:"type"`
+	Reason    string            `json:"reason"`
+	RootCause []opensearchError `json:"root_cause"`
+	CausedBy  *opensearchError  `json:"caused_by"`
+}
+
+// The error and all of its causes
+func (e *opensearchError) flatten() []opensearchError {
+	errs := []opensearchError{*e}
+	for i := range e.RootCause {
+		errs = append(errs, e.RootCause[i].flatten()...)
+	}
+	if e.CausedBy != nil {
+		errs = append(errs, e.CausedBy.flatten()...)
+	}
+	return errs
+}
+
+// Describe a repository error response with the most likely cause
+func diagnoseRepositoryError(res *opensearchapi.Response) error {
+	body := res.String()
+
+	var result struct {
+		Error opensearchError `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("unexpected repository error: %s", body)
+	}
+
+	errs := result.Error.flatten()
+	for _, diagnostic := range repositoryDiagnostics {
+		for _, e := range errs {
+			if slices.Contains(diagnostic.types, e.Type) || slices.ContainsFunc(diagnostic.reasons, func(reason string) bool { return strings.Contains(e.Reason, reason) }) {
+				return fmt.Errorf("%s: %s", diagnostic.hint, body)
+			}
+		}
+	}
+	return fmt.Errorf("unexpected repository error: %s", body)
+}
+
+// Check that the repository exists and that every node can write to it.
+// With testSnapshot, also create and delete an empty snapshot to prove that
+// snapshots can actually be written.
+func checkRepositoryAccess(ctx context.Context, client *clusterClient, repo string, testSnapshot bool) error {
+	if err := checkRepositoryExists(ctx, client, repo); err != nil {
+		return err
+	}
+
+	verifyReq := opensearchapi.SnapshotVerifyRepositoryRequest{Repository: repo}
+	verifyReq.MasterTimeout, verifyReq.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := verifyReq.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	if res.IsError() {
+		defer res.Body.Close()
+		return diagnoseRepositoryError(res)
+	}
+	res.Body.Close()
+
+	if !testSnapshot {
+		return nil
+	}
+
+	snapshot := fmt.Sprintf("graylog-archiver-preflight-%d", time.Now().Unix())
+	waitForCompletion := true
+	createReq := opensearchapi.SnapshotCreateRequest{
+		Repository:        repo,
+		Snapshot:          snapshot,
+		Body:              strings.NewReader(`{"indices": "-*", "include_global_state": false}`),
+		WaitForCompletion: &waitForCompletion,
+	}
+	createReq.MasterTimeout, createReq.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err = createReq.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return diagnoseRepositoryError(res)
+	}
+
+	// The create only reports the outcome in the snapshot state, a failed write is not an error response
+	var result struct {
+		Snapshot snapshotInfo `json:"snapshot"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if err := deleteSnapshot(ctx, client, repo, snapshot); err != nil {
+		return fmt.Errorf("test snapshot %s was written in state %s but could not be deleted: %s", snapshot, result.Snapshot.State, err)
+	}
+	if result.Snapshot.State != "SUCCESS" {
+		return fmt.Errorf("test snapshot %s ended in state %s instead of SUCCESS", snapshot, result.Snapshot.State)
+	}
+	return nil
+}
+
+// Check that the repository is registered. The coordinating node answers from
+// its local cluster state with only the repository type in the response, the
+// full repository get is only used when that light request fails.
+func checkRepositoryExists(ctx context.Context, client *clusterClient, repo string) error {
+	local := true
+	lightReq := opensearchapi.SnapshotGetRepositoryRequest{
+		Repository: []string{repo},
+		Local:      &local,
+		FilterPath: []string{repo + ".type"},
+	}
+	res, err := lightReq.Do(ctx, client)
+	if err == nil {
+		defer res.Body.Close()
+		if !res.IsError() {
+			return nil
+		}
+		if res.StatusCode == http.StatusNotFound {
+			return diagnoseRepositoryError(res)
+		}
+	}
+
+	getReq := opensearchapi.SnapshotGetRepositoryRequest{Repository: []string{repo}}
+	getReq.MasterTimeout, getReq.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err = getReq.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return diagnoseRepositoryError(res)
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Settings of a restore run
+type restoreOptions struct {
+	repo              string
+	snapshot          string
+	renamePattern     string
+	renameReplacement string
+	includeAliases    bool
+	aliasesFromFlag   bool // includeAliases was given on the command line and overrides the snapshot metadata
+	force             bool
+	wait              bool
+	waitTimeout       time.Duration
+}
+
+// Entry point of the restore command
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	opensearchURL := flags.String("url", "", "OpenSearch URL (env OPENSEARCH_URL)")
+	username := flags.String("username", "", "Username for basic authentication (env OPENSEARCH_USERNAME)")
+	password := flags.String("password", "", "Password for basic authentication (env OPENSEARCH_PASSWORD)")
+	caCert := flags.String("ca-cert", "", "PEM file with CA certificates to trust for https connections")
+	insecureSkipVerify := flags.Bool("insecure-skip-verify", false, "Don't verify the cluster's TLS certificate (unsafe, for testing only)")
+	envFile := flags.String("env-file", "", "Load environment variables from this dotenv file, variables already set take precedence")
+	repoName := flags.String("repo", "", "Repository name in OpenSearch")
+	snapshot := flags.String("snapshot", "", "Name or pattern of the snapshots to restore, or to list with --list")
+	list := flags.Bool("list", false, "List the snapshots in the repository matching --snapshot (default: all) and exit")
+	renamePattern := flags.String("rename-pattern", "", "Regular expression matched against the names of the restored indices")
+	renameReplacement := flags.String("rename-replacement", "", "Replacement for --rename-pattern, $1 refers to the first capture group")
+	includeAliases := flags.Bool("include-aliases", true, "Restore the aliases stored with the indices, unless the snapshot metadata says not to")
+	force := flags.Bool("force", false, "Close open indices that are in the way so the restore can replace them")
+	wait := flags.Bool("wait", false, "Wait until every restored shard is recovered")
+	waitTimeout := flags.Duration("wait-timeout", 30*time.Minute, "How long --wait waits for the restore to finish")
+	verboseHTTP := flags.Bool("verbose-http", false, "Log every OpenSearch request and response body (very noisy, for debugging only)")
+	flags.Parse(args)
+
+	if *envFile != "" {
+		if err := loadEnvFile(*envFile); err != nil {
+			fatal.Fatalf("Error loading --env-file: %s", err)
+		}
+	}
+	if err := applyFlagEnvVars(flags); err != nil {
+		fatal.Fatalf("Error reading flags from the environment: %s", err)
+	}
+
+	if *opensearchURL == "" || *repoName == "" || (*snapshot == "" && !*list) {
+		fatal.Fatalf("Missing required arguments. Use restore --help for usage instructions.")
+	}
+	if (*renamePattern == "") != (*renameReplacement == "") {
+		fatal.Fatalf("--rename-pattern and --rename-replacement must be used together.")
+	}
+	if *renamePattern != "" {
+		if _, err := regexp.Compile(*renamePattern); err != nil {
+			fatal.Fatalf("Invalid --rename-pattern: %s", err)
+		}
+	}
+
+	normalizedURL, err := normalizeURL(*opensearchURL, *username != "" || *password != "")
+	if err != nil {
+		fatal.Fatalf("Invalid --url %q: %s", *opensearchURL, err)
+	}
+	client, err := newClient(connectionOptions{
+		address:            normalizedURL,
+		username:           *username,
+		password:           *password,
+		caCert:             *caCert,
+		insecureSkipVerify: *insecureSkipVerify,
+		verboseHTTP:        *verboseHTTP,
+	})
+	if err != nil {
+		fatal.Fatalf("Failed to create OpenSearch client: %s", err)
+	}
+
+	ctx := context.Background()
+
+	if *list {
+		pattern := *snapshot
+		if pattern == "" {
+			pattern = "*"
+		}
+		if err := listSnapshots(ctx, client, *repoName, pattern, os.Stdout); err != nil {
+			fatal.Fatalf("Error listing snapshots: %s", err)
+		}
+		return
+	}
+
+	opts := restoreOptions{
+		repo:              *repoName,
+		snapshot:          *snapshot,
+		renamePattern:     *renamePattern,
+		renameReplacement: *renameReplacement,
+		includeAliases:    *includeAliases,
+		aliasesFromFlag:   flagIsSet(flags, "include-aliases"),
+		force:             *force,
+		wait:              *wait,
+		waitTimeout:       *waitTimeout,
+	}
+	if err := restoreSnapshots(ctx, client, opts); err != nil {
+		fatal.Fatalf("Error restoring snapshots: %s", err)
+	}
+}
+
+// Whether the flag was given on the command line
+func flagIsSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// Snapshots of the repository matching the name or pattern
+func findSnapshots(ctx context.Context, client *clusterClient, repo, pattern string) ([]snapshotInfo, error) {
+	req := opensearchapi.SnapshotGetRequest{
+		Repository: repo,
+		Snapshot:   []string{pattern},
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to list snapshots: %s", res.String())
+	}
+
+	var result struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Snapshots, nil
+}
+
+// Print the snapshots matching the pattern as a table
+func listSnapshots(ctx context.Context, client *clusterClient, repo, pattern string, out io.Writer) error {
+	snapshots, err := findSnapshots(ctx, client, repo, pattern)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SNAPSHOT\tSTATE\tSHARDS\tINDICES")
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", snapshot.Snapshot, snapshot.State, snapshot.Shards.Successful, snapshot.Shards.Total, strings.Join(snapshot.Indices, ","))
+	}
+	return w.Flush()
+}
+
+// Restore every snapshot matching the pattern
+func restoreSnapshots(ctx context.Context, client *clusterClient, opts restoreOptions) error {
+	snapshots, err := findSnapshots(ctx, client, opts.repo, opts.snapshot)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshot matching %s in repository %s", opts.snapshot, opts.repo)
+	}
+
+	var rename *regexp.Regexp
+	if opts.renamePattern != "" {
+		rename = regexp.MustCompile(opts.renamePattern)
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.State != "SUCCESS" && snapshot.State != "PARTIAL" {
+			log.Printf("Skipping snapshot %s in state %s", snapshot.Snapshot, snapshot.State)
+			continue
+		}
+
+		targets := make([]string, len(snapshot.Indices))
+		for i, index := range snapshot.Indices {
+			targets[i] = index
+			if rename != nil {
+				targets[i] = rename.ReplaceAllString(index, goReplacement(opts.renameReplacement, rename.NumSubexp()))
+			}
+		}
+
+		if err := clearRestoreTargets(ctx, client, targets, opts.force); err != nil {
+			return fmt.Errorf("snapshot %s: %s", snapshot.Snapshot, err)
+		}
+
+		log.Printf("Restoring snapshot %s into %s", snapshot.Snapshot, strings.Join(targets, ", "))
+		if err := restoreSnapshot(ctx, client, opts, snapshot); err != nil {
+			return fmt.Errorf("snapshot %s: %s", snapshot.Snapshot, err)
+		}
+
+		if opts.wait {
+			if err := waitForRecovery(ctx, client, targets, opts.waitTimeout); err != nil {
+				return fmt.Errorf("snapshot %s: %s", snapshot.Snapshot, err)
+			}
+			log.Printf("Restored snapshot %s", snapshot.Snapshot)
+		}
+	}
+	return nil
+}
+
+// Translate a Java regex replacement, as OpenSearch applies rename_replacement,
+// into the template syntax of Regexp.Expand. Java reads $12 as group 12 only
+// when the pattern has that many groups and as group 1 followed by "2"
+// otherwise, while Go would read a group named "12_copy" out of "$12_copy".
+func goReplacement(replacement string, groups int) string {
+	var b strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		switch {
+		case c == '\\' && i+1 < len(replacement):
+			i++
+			if replacement[i] == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte(replacement[i])
+			}
+		case c == '$' && i+1 < len(replacement) && replacement[i+1] == '{':
+			// Named groups use the same syntax in both
+			end := strings.IndexByte(replacement[i:], '}')
+			if end < 0 {
+				b.WriteString("$$")
+				continue
+			}
+			b.WriteString(replacement[i : i+end+1])
+			i += end
+		case c == '$' && i+1 < len(replacement) && isDigit(replacement[i+1]):
+			group := int(replacement[i+1] - '0')
+			i++
+			for i+1 < len(replacement) && isDigit(replacement[i+1]) && group*10+int(replacement[i+1]-'0') <= groups {
+				group = group*10 + int(replacement[i+1]-'0')
+				i++
+			}
+			fmt.Fprintf(&b, "${%d}", group)
+		case c == '$':
+			b.WriteString("$$")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// OpenSearch refuses to restore over an open index. Fail unless force is set,
+// in which case the open index is closed so the restore replaces it.
+func clearRestoreTargets(ctx context.Context, client *clusterClient, targets []string, force bool) error {
+	var open []string
+	for _, target := range targets {
+		exists := opensearchapi.IndicesExistsRequest{Index: []string{target}}
+		res, err := exists.Do(ctx, client)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusNotFound {
+			continue
+		}
+
+		indices, err := getIndices(ctx, client, target)
+		if err != nil {
+			return err
+		}
+		if len(indices) > 0 && indices[0].Status == "open" {
+			open = append(open, target)
+		}
+	}
+
+	if len(open) == 0 {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("indices %s already exist and are open, use --force to replace them or --rename-pattern to restore next to them", strings.Join(open, ", "))
+	}
+
+	req := opensearchapi.IndicesCloseRequest{Index: open}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to close indices: %s", res.String())
+	}
+	log.Printf("Closed %s so the restore can replace them", strings.Join(open, ", "))
+	return nil
+}
+
+func restoreSnapshot(ctx context.Context, client *clusterClient, opts restoreOptions, snapshot snapshotInfo) error {
+	includeAliases := opts.includeAliases
+	if recorded, ok := snapshot.Metadata[includeAliasesMetadataKey].(bool); ok && !opts.aliasesFromFlag {
+		includeAliases = recorded
+	}
+
+	request := map[string]interface{}{
+		"indices":              strings.Join(snapshot.Indices, ","),
+		"include_global_state": false,
+		"include_aliases":      includeAliases,
+		"partial":              snapshot.State == "PARTIAL",
+	}
+	if opts.renamePattern != "" {
+		request["rename_pattern"] = opts.renamePattern
+		request["rename_replacement"] = opts.renameReplacement
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req := opensearchapi.SnapshotRestoreRequest{
+		Repository: opts.repo,
+		Snapshot:   snapshot.Snapshot,
+		Body:       bytes.NewReader(body),
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to restore snapshot: %s", res.String())
+	}
+	return nil
+}
+
+// Poll the recovery API until every shard of the indices is recovered from the snapshot
+func waitForRecovery(ctx context.Context, client *clusterClient, indices []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := snapshotInitialPollInterval
+	for {
+		done, total, err := recoveredShards(ctx, client, indices)
+		if err != nil {
+			return err
+		}
+		if total > 0 && done == total {
+			return nil
+		}
+		log.Printf("Restore in progress: %d/%d shards recovered", done, total)
+		if time.Now().After(deadline) {
+			return fmt.Errorf("restore still running after %s, %d/%d shards recovered", timeout, done, total)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, snapshotPollInterval)
+	}
+}
+
+// Number of shards of the indices that finished recovering, and the total
+func recoveredShards(ctx context.Context, client *clusterClient, indices []string) (int, int, error) {
+	req := opensearchapi.IndicesRecoveryRequest{Index: indices}
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, 0, fmt.Errorf("failed to get recovery status: %s", res.String())
+	}
+
+	var result map[string]struct {
+		Shards []struct {
+			Stage string `json:"stage"`
+		} `json:"shards"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return 0, 0, err
+	}
+
+	done, total := 0, 0
+	for _, index := range result {
+		for _, shard := range index.Shards {
+			total++
+			if shard.Stage == "DONE" {
+				done++
+			}
+		}
+	}
+	return done, total, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGoReplacement(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		replacement string
+		index       string
+		want        string
+	}{
+		{name: "group followed by text", pattern: "(.+)", replacement: "restored_$1_copy", index: "uat_42", want: "restored_uat_42_copy"},
+		{name: "group at the end", pattern: "uat_(.+)", replacement: "restored_$1", index: "uat_42", want: "restored_42"},
+		{name: "whole match", pattern: "uat_.+", replacement: "restored_$0", index: "uat_42", want: "restored_uat_42"},
+		{name: "digits beyond group count", pattern: "(.+)", replacement: "$10", index: "uat_42", want: "uat_420"},
+		{name: "two digit group", pattern: "(u)(a)(t)(_)(4)(2)(.*)(.*)(.*)(.*)", replacement: "$10$6", index: "uat_42", want: "2"},
+		{name: "named group", pattern: "(?P<num>\\d+)", replacement: "n${num}_x", index: "uat_42", want: "uat_n42_x"},
+		{name: "escaped dollar", pattern: "uat_(.+)", replacement: "\\$1_$1", index: "uat_42", want: "$1_42"},
+		{name: "no groups", pattern: "uat", replacement: "restored", index: "uat_42", want: "restored_42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rename := regexp.MustCompile(tt.pattern)
+			got := rename.ReplaceAllString(tt.index, goReplacement(tt.replacement, rename.NumSubexp()))
+			if got != tt.want {
+				t.Errorf("renaming %q with %q = %q, want %q", tt.index, tt.replacement, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Prefix of the temporary indices that snapshots are restored into for verification
+const restoreCheckPrefix = "restore-check-"
+
+// Settings for restoring a sample of new snapshots to prove they are usable
+type restoreCheck struct {
+	sampleRate float64
+	timeout    time.Duration
+}
+
+// Whether this snapshot is part of the verified sample
+func (c *restoreCheck) sampled() bool {
+	return c.sampleRate >= 1 || rand.Float64() < c.sampleRate
+}
+
+// Restore the snapshot into a temporary index, compare its document count with
+// the source index and drop the temporary index again
+func verifyRestore(ctx context.Context, l *indexLogger, client *clusterClient, repo, index, snapshot string, timeout time.Duration) error {
+	info, err := waitForSnapshot(ctx, client, repo, snapshot, timeout)
+	if err != nil {
+		return err
+	}
+	if info.State != "SUCCESS" {
+		return fmt.Errorf("snapshot %s ended in state %s", snapshot, info.State)
+	}
+
+	expected, err := countDocuments(ctx, client, []string{index})
+	if err != nil {
+		return err
+	}
+
+	restored := restoreCheckPrefix + index
+	if err := restoreSnapshotAs(ctx, client, repo, snapshot, index, restored); err != nil {
+		return err
+	}
+	defer func() {
+		if err := deleteIndex(ctx, client, restored); err != nil {
+			l.Printf("Error deleting restore check index %s: %s", restored, err)
+		}
+	}()
+
+	if err := waitForClusterHealth(ctx, l, client, restored, "green", timeout); err != nil {
+		return err
+	}
+
+	actual, err := countDocuments(ctx, client, []string{restored})
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("restored index %s has %d documents, source index %s has %d", restored, actual, index, expected)
+	}
+
+	l.Printf("Restore check passed for snapshot %s: %d documents", snapshot, actual)
+	return nil
+}
+
+// Restore a single index from the snapshot under a different name. Aliases are
+// left out so the copy doesn't join those of the live index, and replicas are
+// dropped so the copy turns green on any cluster size.
+func restoreSnapshotAs(ctx context.Context, client *clusterClient, repo, snapshot, index, target string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"indices":              index,
+		"include_global_state": false,
+		"include_aliases":      false,
+		"rename_pattern":       "(.+)",
+		"rename_replacement":   target,
+		"index_settings":       map[string]interface{}{"index.number_of_replicas": 0},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := opensearchapi.SnapshotRestoreRequest{
+		Repository: repo,
+		Snapshot:   snapshot,
+		Body:       bytes.NewReader(body),
+	}
+	req.MasterTimeout, req.ClusterManagerTimeout = client.managerTimeout.values()
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to restore snapshot: %s", res.String())
+	}
+	return nil
+}
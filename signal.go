@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Content of the --done-file and --fail-file markers
+type completionSignal struct {
+	Status     string      `json:"status"`
+	Summary    *runSummary `json:"summary,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	FinishedAt time.Time   `json:"finished_at"`
+}
+
+// Write the file atomically so that watchers never see partial content
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeCompletionSignal(path string, signal completionSignal) error {
+	data, err := json.MarshalIndent(signal, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// Signal the end of a run through --done-file when nothing failed, or --fail-file otherwise
+func signalCompletion(doneFile, failFile string, summary runSummary) {
+	signal := completionSignal{Status: "success", Summary: &summary, FinishedAt: time.Now()}
+	path := doneFile
+	if summary.Failed > 0 {
+		signal.Status = "failed"
+		path = failFile
+	}
+	if path == "" {
+		return
+	}
+
+	if err := writeCompletionSignal(path, signal); err != nil {
+		log.Printf("Error writing completion file %s: %s", path, err)
+	}
+}
+
+// Logs fatal errors to stderr and then writes the --fail-file, if any, before exiting
+type fatalLogger struct {
+	*log.Logger
+	failFile string
+}
+
+func (l *fatalLogger) Fatalf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	l.Output(2, message)
+
+	if l.failFile != "" {
+		signal := completionSignal{Status: "failed", Error: message, FinishedAt: time.Now()}
+		if err := writeCompletionSignal(l.failFile, signal); err != nil {
+			l.Printf("Error writing completion file %s: %s", l.failFile, err)
+		}
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// Returned instead of creating a snapshot when a failure is simulated
+var errSimulatedFailure = errors.New("simulated failure (--simulate-failure-rate)")
+
+// Deterministically fails a fraction of snapshot creations, for exercising
+// summaries, exit codes and notifications. Not meant for production runs.
+type failureSimulator struct {
+	rate float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFailureSimulator(rate float64, seed int64) *failureSimulator {
+	return &failureSimulator{rate: rate, rng: rand.New(rand.NewSource(seed))}
+}
+
+// Whether the next snapshot creation should fail
+func (s *failureSimulator) fail() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < s.rate
+}
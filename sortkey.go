@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported values for --sort-key-type
+var sortKeyTypes = []string{"number", "string"}
+
+// Extracts the part of an index name that decides its position, set with
+// --sort-key-regex. Without it indices sort on their trailing number.
+type sortKey struct {
+	re      *regexp.Regexp
+	numeric bool
+}
+
+// Compile --sort-key-regex, which must capture the sort key in its first group
+func newSortKey(expr, keyType string) (*sortKey, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("expression %q has no capture group", expr)
+	}
+	return &sortKey{re: re, numeric: keyType == "number"}, nil
+}
+
+// The captured key of the index name, false when the name doesn't match
+func (k *sortKey) extract(index string) (string, bool) {
+	match := k.re.FindStringSubmatch(index)
+	if match == nil {
+		return "", false
+	}
+	key := match[1]
+	if k.numeric {
+		if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+			return "", false
+		}
+	}
+	return key, true
+}
+
+// Whether index a sorts before index b. Names without a key sort first.
+func (k *sortKey) less(a, b string) bool {
+	keyA, okA := k.extract(a)
+	keyB, okB := k.extract(b)
+	if okA != okB {
+		return !okA
+	}
+	if !okA || keyA == keyB {
+		return a < b
+	}
+	if k.numeric {
+		numA, _ := strconv.ParseInt(keyA, 10, 64)
+		numB, _ := strconv.ParseInt(keyB, 10, 64)
+		return numA < numB
+	}
+	return strings.Compare(keyA, keyB) < 0
+}
+
+// Warn about indices the sort key can't place, they are treated as the oldest
+func (k *sortKey) warnUnmatched(indices []IndexInfo) {
+	for _, index := range indices {
+		if _, ok := k.extract(index.Name); !ok {
+			log.Printf("Warning: no sort key in index name %s (--sort-key-regex %s), sorting it as the oldest", index.Name, k.re)
+		}
+	}
+}
+
+// Whether index a comes before index b in name order, by the sort key if set
+// and by the trailing number otherwise
+func indexNameLess(key *sortKey, a, b string) bool {
+	if key != nil {
+		return key.less(a, b)
+	}
+	return extractIndexNumber(a) < extractIndexNumber(b)
+}
+
+// Sort indices into name order, oldest first
+func sortIndicesByName(indices []IndexInfo, key *sortKey) {
+	sort.SliceStable(indices, func(i, j int) bool {
+		return indexNameLess(key, indices[i].Name, indices[j].Name)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/opensearch-project/opensearch-go/v2"
+)
+
+// How to connect to the cluster, shared by all commands
+type connectionOptions struct {
+	address            string // Already normalized with normalizeURL
+	username           string
+	password           string
+	caCert             string
+	insecureSkipVerify bool
+	verboseHTTP        bool
+}
+
+// OpenSearch client along with the request parameters resolved for the cluster
+type clusterClient struct {
+	*opensearch.Client
+	managerTimeout managerTimeoutParam // Sent with every request that supports it
+}
+
+func newClient(opts connectionOptions) (*clusterClient, error) {
+	config := opensearch.Config{
+		Addresses: []string{opts.address},
+		Username:  opts.username,
+		Password:  opts.password,
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if opts.caCert != "" || opts.insecureSkipVerify {
+		tlsTransport, err := newTLSTransport(opts.caCert, opts.insecureSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up TLS: %s", err)
+		}
+		if opts.insecureSkipVerify {
+			log.Println("Warning: TLS certificate verification is disabled")
+		}
+		transport = tlsTransport
+	}
+	if opts.verboseHTTP {
+		transport = &verboseHTTPTransport{next: transport}
+	}
+	config.Transport = transport
+
+	client, err := opensearch.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterClient{Client: client}, nil
+}
+
+// HTTP transport trusting the CA certificates of the PEM file, if any, on top
+// of the system roots. The TLS config is set up here rather than through
+// opensearch.Config.CACert, which only works when the client owns the
+// transport and so can't be combined with --verbose-http.
+func newTLSTransport(caCertFile string, insecureSkipVerify bool) (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+
+	if caCertFile != "" {
+		pem, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}